@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Delay after which a batch is sent if WithWriteBatching is given no delay
+const defaultBatchDelay = time.Millisecond
+
+// Future is the result of a write that may be sent to the server later
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Returns a future that is already done
+func completedFuture(err error) *Future {
+	f := newFuture()
+	f.complete(err)
+
+	return f
+}
+
+// Wait blocks until the write was sent and returns its error
+func (f *Future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// Done returns a channel that is closed once the write was sent
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *Future) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// BatchWriter is implemented by the caches that can batch their writes, which callers reach with a type assertion on a
+// Cache. The caches of this package implement it, but only the redis cache batches when WithWriteBatching is given.
+// The other cache types write immediately
+type BatchWriter interface {
+	SetAsync(key string, value interface{}) *Future
+	DeleteAsync(key string) *Future
+	Sync() error
+}
+
+// redisBatcher collects the writes to redis and sends them in a single pipeline once maxItems writes are pending or
+// maxDelay passed since the first of them. Batches are sent one at a time in the order the writes were made
+type redisBatcher struct {
+	client     *redis.Client
+	expiration time.Duration
+	maxItems   int
+	maxDelay   time.Duration
+	sendMu     sync.Mutex
+	mu         sync.Mutex
+	pending    []batchOp
+	timer      *time.Timer
+}
+
+// batchOp is a pending write. A nil value deletes the key
+type batchOp struct {
+	key    string
+	value  []byte
+	future *Future
+}
+
+func newRedisBatcher(client *redis.Client, expiration time.Duration, maxItems int, maxDelay time.Duration) *redisBatcher {
+	return &redisBatcher{
+		client:     client,
+		expiration: expiration,
+		maxItems:   maxItems,
+		maxDelay:   maxDelay,
+	}
+}
+
+// This stores the value to the key like Set, but returns without waiting for the write when the redis write batcher
+// is enabled. The error is returned by the Wait method of the future. The other cache types write immediately
+func (c *cache) SetAsync(key string, value interface{}) *Future {
+	if err := validateKey(key); err != nil {
+		return completedFuture(err)
+	}
+
+	if c.batcher == nil {
+		return completedFuture(c.Set(key, value))
+	}
+
+	val, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		return completedFuture(err)
+	}
+
+	return c.batcher.add(c.key(key), val)
+}
+
+// This deletes the key like Delete, but returns without waiting for the write when the redis write batcher is enabled.
+// See SetAsync
+func (c *cache) DeleteAsync(key string) *Future {
+	if err := validateKey(key); err != nil {
+		return completedFuture(err)
+	}
+
+	if c.batcher == nil {
+		c.Delete(key)
+		return completedFuture(nil)
+	}
+
+	return c.batcher.add(c.key(key), nil)
+}
+
+// This sends the pending writes of the redis write batcher and waits for them. Returns the first error of the writes.
+// It is a no-op for the other cache types
+func (c *cache) Sync() error {
+	if c.batcher == nil {
+		return nil
+	}
+
+	return c.batcher.flush()
+}
+
+// Sends the pending writes of the redis write batcher before an operation that is not batched, so that it sees them
+func (c *cache) flushBatch() {
+	if c.batcher != nil {
+		_ = c.batcher.flush()
+	}
+}
+
+// Queues the write and sends the batch if it is full
+func (b *redisBatcher) add(key string, value []byte) *Future {
+	op := batchOp{key: key, value: value, future: newFuture()}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, op)
+	full := len(b.pending) >= b.maxItems
+	if len(b.pending) == 1 && !full {
+		b.timer = time.AfterFunc(b.maxDelay, func() {
+			_ = b.flush()
+		})
+	}
+	b.mu.Unlock()
+
+	if full {
+		_ = b.flush()
+	}
+
+	return op.future
+}
+
+// Sends the pending writes in one pipeline and completes their futures. The chunks of the streamed values that they
+// overwrote or deleted are removed afterwards. Returns the first error of the writes
+func (b *redisBatcher) flush() error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	b.mu.Lock()
+	ops := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(ops))
+	for i, op := range ops {
+		cmds[i] = replaceRedis(pipe, op.key, op.value, b.expiration)
+	}
+
+	_, _ = pipe.Exec()
+
+	var first error
+	var chunks []string
+	for i, op := range ops {
+		manifest, err := replacedManifest(cmds[i])
+		if manifest != nil {
+			for n := 0; n < manifest.Chunks; n++ {
+				chunks = append(chunks, chunkKey(op.key, *manifest, n))
+			}
+		}
+
+		if err != nil && first == nil {
+			first = err
+		}
+
+		op.future.complete(err)
+	}
+
+	if len(chunks) > 0 {
+		b.client.Del(chunks...)
+	}
+
+	return first
+}
@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Name of the bucket that is used when no namespace is configured
+	defaultBoltBucket = "cache"
+	// Maximum number of expired keys that are deleted in one transaction, so that writers are not blocked for long
+	boltCleanupBatchSize = 1000
+)
+
+// The bbolt databases that are open in this process by their absolute path. bbolt locks the file, so the caches
+// that share a database file share one *bolt.DB, which is closed when the last of them is closed
+var (
+	boltDBsMu sync.Mutex
+	boltDBs   = make(map[string]*sharedBoltDB)
+)
+
+type sharedBoltDB struct {
+	db   *bolt.DB
+	refs int
+}
+
+// Opens the bbolt database at path, or returns the one that is already open, and creates the bucket of the namespace
+func openBoltDB(path, bucket string, perm permissions) (*bolt.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	boltDBsMu.Lock()
+	defer boltDBsMu.Unlock()
+
+	shared, found := boltDBs[abs]
+	if !found {
+		db, err := openBoltFile(path, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		shared = &sharedBoltDB{db: db}
+	}
+
+	if err := shared.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	}); err != nil {
+		if !found {
+			_ = shared.db.Close()
+		}
+
+		return nil, err
+	}
+
+	shared.refs++
+	boltDBs[abs] = shared
+
+	return shared.db, nil
+}
+
+func openBoltFile(path string, perm permissions) (*bolt.DB, error) {
+	db, err := bolt.Open(path, perm.fileMode, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, perm.fileMode); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err := perm.chown(path); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Releases a database that was returned by openBoltDB and closes it if no other cache uses it
+func releaseBoltDB(db *bolt.DB) error {
+	boltDBsMu.Lock()
+	defer boltDBsMu.Unlock()
+
+	for path, shared := range boltDBs {
+		if shared.db != db {
+			continue
+		}
+
+		shared.refs--
+		if shared.refs > 0 {
+			return nil
+		}
+
+		delete(boltDBs, path)
+	}
+
+	return db.Close()
+}
+
+// Values are stored with the unix time in nanoseconds at which they expire in front, 0 means never, followed by
+// their version
+func encodeBoltValue(val []byte, expires int64, version uint64) []byte {
+	record := make([]byte, 16+len(val))
+	binary.BigEndian.PutUint64(record, uint64(expires))
+	binary.BigEndian.PutUint64(record[8:], version)
+	copy(record[16:], val)
+
+	return record
+}
+
+// Decodes a stored value. The returned value is copied, since bbolt values are only valid during the transaction
+func decodeBoltValue(record []byte) ([]byte, int64, uint64, error) {
+	if len(record) < 16 {
+		return nil, 0, 0, ErrCacheCorrupted
+	}
+
+	expires := int64(binary.BigEndian.Uint64(record))
+	version := binary.BigEndian.Uint64(record[8:])
+	val := make([]byte, len(record)-16)
+	copy(val, record[16:])
+
+	return val, expires, version, nil
+}
+
+// Reads the value of the key in the transaction. Returns ErrCacheExpired if it expired
+func (c *cache) boltGet(tx *bolt.Tx, key string) ([]byte, int64, error) {
+	val, expires, _, err := c.boltGetWithVersion(tx, key)
+
+	return val, expires, err
+}
+
+// Reads the value of the key and its version in the transaction
+func (c *cache) boltGetWithVersion(tx *bolt.Tx, key string) ([]byte, int64, uint64, error) {
+	record := tx.Bucket(c.boltBucket).Get([]byte(key))
+	if record == nil {
+		return nil, 0, 0, ErrCacheNotFound
+	}
+
+	val, expires, version, err := decodeBoltValue(record)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if expires > 0 && time.Now().UnixNano() > expires {
+		return nil, 0, 0, ErrCacheExpired
+	}
+
+	return val, expires, version, nil
+}
+
+// Stores the value with the next sequence number of the bucket as its version
+func (c *cache) boltPut(tx *bolt.Tx, key string, val []byte, expires int64) error {
+	bucket := tx.Bucket(c.boltBucket)
+
+	version, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(key), encodeBoltValue(val, expires, version))
+}
+
+// Changes the expiration of the value without changing its version. Callers check that the value exists
+func (c *cache) boltSetExpiry(tx *bolt.Tx, key string, expires int64) error {
+	bucket := tx.Bucket(c.boltBucket)
+
+	record := append([]byte{}, bucket.Get([]byte(key))...)
+	binary.BigEndian.PutUint64(record, uint64(expires))
+
+	return bucket.Put([]byte(key), record)
+}
+
+// Returns value from bolt cache for given key. Removes current cache depending on second parameter
+func (c *cache) getBoltCache(key string, removeCurrent bool) ([]byte, error) {
+	val, _, err := c.getBoltCacheWithVersion(key, removeCurrent)
+
+	return val, err
+}
+
+// Returns the value and its version like getBoltCache
+func (c *cache) getBoltCacheWithVersion(key string, removeCurrent bool) ([]byte, uint64, error) {
+	var val []byte
+	var version uint64
+
+	if !removeCurrent && !c.sliding {
+		err := c.boltDB.View(func(tx *bolt.Tx) error {
+			var err error
+			val, _, version, err = c.boltGetWithVersion(tx, key)
+			return err
+		})
+
+		return val, version, err
+	}
+
+	err := c.boltDB.Update(func(tx *bolt.Tx) error {
+		var expires int64
+		var err error
+
+		val, expires, version, err = c.boltGetWithVersion(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if removeCurrent {
+			return tx.Bucket(c.boltBucket).Delete([]byte(key))
+		}
+
+		if expires > 0 {
+			return c.boltSetExpiry(tx, key, c.expiresAt())
+		}
+
+		return nil
+	})
+
+	return val, version, err
+}
+
+// Updates the counter in a single transaction. The expiration of an existing key is kept
+func (c *cache) incrBoltCache(key string, delta int64) (int64, error) {
+	var value int64
+
+	err := c.boltDB.Update(func(tx *bolt.Tx) error {
+		val, expires, err := c.boltGet(tx, key)
+		if err != nil {
+			value = delta
+			return c.boltPut(tx, key, []byte(strconv.FormatInt(value, 10)), c.expiresAt())
+		}
+
+		current, err := parseCounter(val)
+		if err != nil {
+			return err
+		}
+
+		value = current + delta
+
+		return c.boltPut(tx, key, []byte(strconv.FormatInt(value, 10)), expires)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Deletes all the keys that start with prefix. The keys are sorted, so only the matching range is scanned
+func (c *cache) deleteBoltPrefix(prefix string) error {
+	return c.boltDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.boltBucket)
+		cursor := bucket.Cursor()
+
+		var keys [][]byte
+		for k, _ := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Removes the expired keys from the bolt cache in batches of boltCleanupBatchSize keys per transaction
+func (c *cache) removeExpiredBoltKeys() {
+	var from []byte
+
+	for {
+		var expired [][]byte
+		var next []byte
+		now := time.Now().UnixNano()
+
+		if err := c.boltDB.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(c.boltBucket).Cursor()
+
+			k, v := cursor.First()
+			if from != nil {
+				k, v = cursor.Seek(from)
+			}
+
+			for ; k != nil; k, v = cursor.Next() {
+				if len(expired) == boltCleanupBatchSize {
+					next = append([]byte(nil), k...)
+					break
+				}
+
+				if len(v) >= 8 {
+					if expires := int64(binary.BigEndian.Uint64(v)); expires > 0 && now > expires {
+						expired = append(expired, append([]byte(nil), k...))
+					}
+				}
+			}
+
+			return nil
+		}); err != nil {
+			return
+		}
+
+		if len(expired) > 0 {
+			if err := c.boltDB.Update(func(tx *bolt.Tx) error {
+				bucket := tx.Bucket(c.boltBucket)
+				for _, k := range expired {
+					// The key may have been set again since it was read
+					if _, _, err := c.boltGet(tx, string(k)); err == ErrCacheExpired {
+						if err := bucket.Delete(k); err != nil {
+							return err
+						}
+					}
+				}
+
+				return nil
+			}); err != nil {
+				return
+			}
+		}
+
+		if next == nil {
+			return
+		}
+
+		from = next
+	}
+}
@@ -1,33 +1,55 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/go-redis/redis/v7"
+	bolt "go.etcd.io/bbolt"
 )
 
 var (
-	cacheTypeDefault  = "memory"
-	cacheTypeFile     = "file"
-	cacheTypeRedis    = "redis"
-	cacheTypeMemcache = "memcache"
-	defaultExpiration = 0 * time.Second
+	cacheTypeDefault      = "memory"
+	cacheTypeFile         = "file"
+	cacheTypeRedis        = "redis"
+	cacheTypeMemcache     = "memcache"
+	cacheTypeLog          = "log"
+	cacheTypeBolt         = "bolt"
+	cacheTypeMetaMemcache = "memcache-meta"
+	defaultExpiration     = 0 * time.Second
 )
 
+// Returns the value and resets its time to live if the key has one. Persisted keys are not given an expiration
+var redisGetAndSlide = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value and redis.call("PTTL", KEYS[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return value
+`)
+
 var (
 	ErrCacheNotFound      = errors.New("cache lib: cache not found")
 	ErrCacheExpired       = errors.New("cache lib: cache expired")
 	ErrConnectingRedis    = errors.New("cache lib: cannot connect to redis server")
 	ErrCreatingFile       = errors.New("cache lib: cannot create file on the given path")
 	ErrCacheAlreadyExists = errors.New("cache lib: cache already exists")
+	ErrInvalidDSN         = errors.New("cache lib: invalid dsn")
+	ErrNotInteger         = errors.New("cache lib: cache value is not an integer")
+	ErrCASConflict        = errors.New("cache lib: cache was modified since it was read")
+	ErrNotSupported       = errors.New("cache lib: operation is not supported by the cache type")
+	ErrCacheCorrupted     = errors.New("cache lib: cache is corrupted")
+	ErrInvalidKey         = errors.New("cache lib: invalid cache key")
+	ErrNotHash            = errors.New("cache lib: cache value is not a hash")
+	ErrInvalidTTL         = errors.New("cache lib: ttl must be positive")
 )
 
 type Cache interface {
@@ -38,14 +60,37 @@ type Cache interface {
 	Has(key string) bool
 	Delete(key string)
 	Flush()
+	GetMulti(keys []string) (map[string][]byte, error)
+	SetMulti(items map[string]interface{}) error
+	DeleteMulti(keys []string) error
+	Increment(key string, delta int64) (int64, error)
+	Decrement(key string, delta int64) (int64, error)
+	GetWithVersion(key string) ([]byte, Version, error)
+	CompareAndSwap(key string, version Version, value interface{}) error
+	TTL(key string) (time.Duration, error)
+	Touch(key string, ttl time.Duration) error
+	Persist(key string) error
+	Stats() Stats
+	DeletePrefix(prefix string) error
+	GetMapped(key string) (*MappedValue, error)
+	SetStream(key string, r io.Reader) error
+	GetStream(key string) (io.ReadCloser, error)
+	HSetFields(key string, fields map[string]interface{}) error
+	HGetField(key string, field string) ([]byte, error)
+	HGetAll(key string) (map[string][]byte, error)
+	Close() error
 }
 
 type cacheItem struct {
 	value      []byte
 	expiration int64
+	created    int64
+	version    uint64
+	fields     map[string][]byte
 }
 
 type cache struct {
+	stats          cacheStats
 	mu             sync.RWMutex
 	cacheType      string
 	expiration     time.Duration
@@ -53,8 +98,29 @@ type cache struct {
 	filePath       string
 	cacheFiles     map[string]struct{}
 	redisClient    *redis.Client
+	batcher        *redisBatcher
 	memCacheClient *memcache.Client
+	metaClient     *metaClient
 	cleaner        *cacheCleaner
+	maxEntries     int
+	prefix         string
+	version        uint64
+	sliding        bool
+	durability     Durability
+	maxBytes       int64
+	maxFiles       int
+	eviction       EvictionPolicy
+	janitor        *cacheCleaner
+	accessMu       sync.Mutex
+	fileAccess     map[string]int64
+	fileExpiry     map[string]fileExpiry
+	quarantine     bool
+	logStore       *logStore
+	boltDB         *bolt.DB
+	boltBucket     []byte
+	perm           permissions
+	mmapThreshold  int64
+	clock          func() time.Time
 }
 
 type cacheCleaner struct {
@@ -63,8 +129,9 @@ type cacheCleaner struct {
 }
 
 // expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
-func NewDefaultCache(expiration time.Duration) (Cache, error) {
+func NewDefaultCache(expiration time.Duration, opts ...Option) (Cache, error) {
 	var cleaner *cacheCleaner
+	o := newOptions(opts)
 
 	if expiration <= defaultExpiration {
 		expiration = defaultExpiration
@@ -80,6 +147,8 @@ func NewDefaultCache(expiration time.Duration) (Cache, error) {
 		expiration: expiration,
 		items:      make(map[string]cacheItem),
 		cleaner:    cleaner,
+		maxEntries: o.maxEntries,
+		sliding:    o.sliding,
 	}
 
 	cache.cleanExpiredCache()
@@ -89,8 +158,9 @@ func NewDefaultCache(expiration time.Duration) (Cache, error) {
 
 // expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
 // path string directory path where the cache file can be stored. It should have write permission
-func NewFileCache(expiration time.Duration, path string) (Cache, error) {
+func NewFileCache(expiration time.Duration, path string, opts ...Option) (Cache, error) {
 	var cleaner *cacheCleaner
+	o := newOptions(opts)
 
 	if expiration <= defaultExpiration {
 		expiration = defaultExpiration
@@ -102,23 +172,135 @@ func NewFileCache(expiration time.Duration, path string) (Cache, error) {
 	}
 
 	cache := &cache{
-		cacheType:  cacheTypeFile,
+		cacheType:     cacheTypeFile,
+		expiration:    expiration,
+		filePath:      path,
+		cacheFiles:    make(map[string]struct{}),
+		cleaner:       cleaner,
+		sliding:       o.sliding,
+		durability:    o.durability,
+		maxBytes:      o.maxBytes,
+		maxFiles:      o.maxFiles,
+		eviction:      o.eviction,
+		fileAccess:    make(map[string]int64),
+		fileExpiry:    make(map[string]fileExpiry),
+		quarantine:    o.quarantine,
+		perm:          newPermissions(o),
+		mmapThreshold: o.mmapThreshold,
+	}
+
+	if err := cache.perm.prepareDir(path, o.createDir); err != nil {
+		return nil, err
+	}
+
+	if err := cache.loadCacheFiles(); err != nil {
+		return nil, err
+	}
+
+	if cache.maxBytes > 0 || cache.maxFiles > 0 {
+		cache.janitor = &cacheCleaner{
+			interval: time.NewTimer(o.janitor),
+			stop:     make(chan bool),
+		}
+
+		cache.enforceFileQuota()
+		cache.runJanitor(o.janitor, cache.enforceFileQuota)
+	}
+
+	cache.cleanExpiredCache()
+
+	return cache, nil
+}
+
+// expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
+// path string directory path where the log segments are stored. It should have write permission
+func NewLogCache(expiration time.Duration, path string, opts ...Option) (Cache, error) {
+	o := newOptions(opts)
+
+	if expiration <= defaultExpiration {
+		expiration = defaultExpiration
+	}
+
+	perm := newPermissions(o)
+	if err := perm.prepareDir(path, o.createDir); err != nil {
+		return nil, err
+	}
+
+	store, err := openLogStore(path, o.durability, o.segmentSize, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &cache{
+		cacheType:  cacheTypeLog,
 		expiration: expiration,
 		filePath:   path,
-		cacheFiles: make(map[string]struct{}),
-		cleaner:    cleaner,
+		sliding:    o.sliding,
+		logStore:   store,
+		perm:       perm,
+		janitor: &cacheCleaner{
+			interval: time.NewTimer(o.janitor),
+			stop:     make(chan bool),
+		},
 	}
 
-	cache.cleanExpiredCache()
+	cache.runJanitor(o.janitor, func() {
+		_ = cache.logStore.compact()
+	})
 
 	return cache, nil
 }
 
 // expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
-func NewRedisCache(expiration time.Duration, host, password string) (Cache, error) {
+// path string path of the bbolt database file. The directory should have write permission
+func NewBoltCache(expiration time.Duration, path string, opts ...Option) (Cache, error) {
+	o := newOptions(opts)
+
+	if expiration <= defaultExpiration {
+		expiration = defaultExpiration
+	}
+
+	bucket := o.namespace
+	if bucket == "" {
+		bucket = defaultBoltBucket
+	}
+
+	perm := newPermissions(o)
+	if err := perm.prepareDir(filepath.Dir(path), o.createDir); err != nil {
+		return nil, err
+	}
+
+	db, err := openBoltDB(path, bucket, perm)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	cache := &cache{
+		cacheType:  cacheTypeBolt,
+		expiration: expiration,
+		filePath:   path,
+		sliding:    o.sliding,
+		boltDB:     db,
+		boltBucket: []byte(bucket),
+		perm:       perm,
+		janitor: &cacheCleaner{
+			interval: time.NewTimer(o.janitor),
+			stop:     make(chan bool),
+		},
+	}
+
+	cache.runJanitor(o.janitor, cache.removeExpiredBoltKeys)
+
+	return cache, nil
+}
+
+// expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
+func NewRedisCache(expiration time.Duration, host, password string, opts ...Option) (Cache, error) {
+	o := newOptions(opts)
 	client := redis.NewClient(&redis.Options{
 		Addr:     host,
 		Password: password,
+		DB:       o.database,
 	})
 
 	if _, err := client.Ping().Result(); err != nil {
@@ -129,50 +311,113 @@ func NewRedisCache(expiration time.Duration, host, password string) (Cache, erro
 		expiration = defaultExpiration
 	}
 
-	return &cache{
+	cache := &cache{
 		cacheType:   cacheTypeRedis,
 		expiration:  expiration,
 		redisClient: client,
-	}, nil
+		prefix:      o.prefix,
+		sliding:     o.sliding,
+	}
+
+	if o.batchItems > 0 {
+		cache.batcher = newRedisBatcher(client, expiration, o.batchItems, o.batchDelay)
+	}
+
+	return cache, nil
 }
 
 // expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
 func NewMemCache(expiration time.Duration, server ...string) (Cache, error) {
+	return NewMemCacheWithOptions(expiration, server)
+}
+
+// expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
+// servers []string list of memcache servers to connect to
+func NewMemCacheWithOptions(expiration time.Duration, servers []string, opts ...Option) (Cache, error) {
+	o := newOptions(opts)
 	if expiration <= defaultExpiration {
 		expiration = defaultExpiration
 	}
 
-	memCacheClient := memcache.New(server...)
+	if err := validateMemcachePrefix(o.prefix); err != nil {
+		return nil, err
+	}
+
+	selector, ring, err := newServerSelector(servers, o)
+	if err != nil {
+		return nil, err
+	}
+
+	memCacheClient := memcache.NewFromSelector(selector)
 	if err := memCacheClient.Ping(); err != nil {
 		return nil, err
 	}
 
-	return &cache{
+	cache := &cache{
 		cacheType:      cacheTypeMemcache,
 		expiration:     expiration,
 		memCacheClient: memCacheClient,
-	}, nil
+		metaClient:     newMetaClient(selector),
+		prefix:         o.prefix,
+		sliding:        o.sliding,
+		clock:          time.Now,
+	}
+
+	if ring != nil {
+		cache.runHealthChecks(ring, o.healthCheck)
+	}
+
+	return cache, nil
 }
 
 // Delete deletes cache for the given key
 func (c *cache) Delete(key string) {
+	if validateKey(key) != nil {
+		return
+	}
+
+	if c.batcher != nil {
+		_ = c.DeleteAsync(key).Wait()
+		return
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
 	switch c.cacheType {
 	case cacheTypeDefault:
 		delete(c.items, key)
 	case cacheTypeFile:
-		_ = os.Remove(c.filePath + "/" + key)
+		_ = c.removeCacheFile(key)
+		delete(c.cacheFiles, key)
 	case cacheTypeRedis:
-		c.redisClient.Del(key)
+		c.deleteChunkedValue(key)
+		c.redisClient.Del(c.key(key))
 	case cacheTypeMemcache:
-		_ = c.memCacheClient.Delete(key)
+		c.deleteChunkedValue(key)
+		_ = c.memCacheClient.Delete(c.key(key))
+	case cacheTypeMetaMemcache:
+		c.deleteChunkedValue(key)
+		_, _ = c.metaClient.delete(c.key(key))
+	case cacheTypeLog:
+		_ = c.logStore.remove(key)
+	case cacheTypeBolt:
+		_ = c.boltDB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(c.boltBucket).Delete([]byte(key))
+		})
 	}
 }
 
-// Flush deletes all the existing cache
+// Flush deletes all the existing cache. Memcache cannot list keys, so the whole server is flushed even if a prefix is configured
 func (c *cache) Flush() {
+	c.flushBatch()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -181,74 +426,242 @@ func (c *cache) Flush() {
 		c.items = make(map[string]cacheItem)
 	case cacheTypeFile:
 		for key := range c.cacheFiles {
-			_ = os.Remove(c.filePath + "/" + key)
+			_ = c.removeCacheFile(key)
 		}
+
+		c.cacheFiles = make(map[string]struct{})
 	case cacheTypeRedis:
-		c.redisClient.FlushAll()
+		c.flushRedis()
 	case cacheTypeMemcache:
 		_ = c.memCacheClient.FlushAll()
+	case cacheTypeMetaMemcache:
+		_ = c.metaClient.flushAll()
+	case cacheTypeLog:
+		_ = c.logStore.flush()
+	case cacheTypeBolt:
+		_ = c.boltDB.Update(func(tx *bolt.Tx) error {
+			// The sequence is the version of the values, so the new bucket carries it on to keep old versions stale
+			sequence := tx.Bucket(c.boltBucket).Sequence()
+			if err := tx.DeleteBucket(c.boltBucket); err != nil {
+				return err
+			}
+
+			bucket, err := tx.CreateBucket(c.boltBucket)
+			if err != nil {
+				return err
+			}
+
+			return bucket.SetSequence(sequence)
+		})
+	}
+}
+
+// This stops the background jobs of the cache and releases its connections and files. A bolt database that is shared
+// with other caches is closed with the last of them. The cache must not be used after it was closed
+func (c *cache) Close() error {
+	if c.cleaner != nil && (c.cacheType == cacheTypeDefault || c.cacheType == cacheTypeFile) {
+		runtime.SetFinalizer(c.cleaner, nil)
+		stopCleaningRoutine(c.cleaner)
+	}
+
+	if c.janitor != nil {
+		runtime.SetFinalizer(c.janitor, nil)
+		stopCleaningRoutine(c.janitor)
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cleaner, c.janitor = nil, nil
+
+	switch c.cacheType {
+	case cacheTypeRedis:
+		return c.redisClient.Close()
+	case cacheTypeMemcache, cacheTypeMetaMemcache:
+		c.metaClient.close()
+	case cacheTypeLog:
+		return c.logStore.close()
+	case cacheTypeBolt:
+		if c.boltDB == nil {
+			return nil
+		}
+
+		db := c.boltDB
+		c.boltDB = nil
+
+		return releaseBoltDB(db)
 	}
+
+	return nil
 }
 
 // This will set the value to the key depending on the cache type user selects (memory, file, redis).
 // If cache already exists for given key, it will return error. Returns error if there are any
 func (c *cache) Add(key string, value interface{}) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	val, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		return err
+	}
+
+	c.flushBatch()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.add(key, val)
+}
+
+// Stores the value if the key doesn't exist. Redis and memcache use their conditional sets, so that it is atomic
+// across clients. The other cache types check and set while holding the lock of the key
+func (c *cache) add(key string, val []byte) error {
+	switch c.cacheType {
+	case cacheTypeRedis:
+		return c.addRedisCache(key, val)
+	case cacheTypeMemcache:
+		return c.addMemCache(key, val)
+	case cacheTypeMetaMemcache:
+		return c.addMetaCache(key, val)
+	}
+
 	if c.has(key) {
 		return ErrCacheAlreadyExists
 	}
 
-	return c.set(key, value)
+	return c.setBytes(key, val)
+}
+
+// Stores the value with SETNX
+func (c *cache) addRedisCache(key string, val []byte) error {
+	added, err := c.redisClient.SetNX(c.key(key), val, c.expiration).Result()
+	if err != nil {
+		return err
+	}
+
+	if !added {
+		return ErrCacheAlreadyExists
+	}
+
+	return nil
+}
+
+// Stores the value with the add command of memcache. Large values are stored in chunks like in setBytes
+func (c *cache) addMemCache(key string, val []byte) error {
+	stored, written, err := c.chunkLargeValue(key, val)
+	if err != nil {
+		return err
+	}
+
+	err = c.memCacheClient.Add(&memcache.Item{
+		Key:        c.key(key),
+		Value:      stored,
+		Expiration: c.memcacheExpiration(c.expiration),
+	})
+
+	if err != nil && written != nil {
+		c.deleteChunks(key, *written)
+	}
+
+	if err == memcache.ErrNotStored {
+		return ErrCacheAlreadyExists
+	}
+
+	return err
 }
 
 // This will set the value to the key depending on the cache type user selects (memory, file, redis).
 // This will override the existing value in the cache. Returns error if there are any
 func (c *cache) Set(key string, value interface{}) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if c.batcher != nil {
+		return c.SetAsync(key, value).Wait()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	return c.set(key, value)
 }
 
 func (c *cache) set(key string, value interface{}) error {
-	var expiration int64
 	val, err := json.MarshalIndent(value, "", " ")
 	if err != nil {
 		return err
 	}
 
-	if c.expiration > defaultExpiration {
-		expiration = time.Now().Add(c.expiration).UnixNano()
-	}
+	return c.replaceBytes(key, val)
+}
+
+// Stores the already encoded value to the key
+func (c *cache) setBytes(key string, val []byte) error {
+	expiration := c.expiresAt()
 
 	switch c.cacheType {
 	case cacheTypeDefault:
+		if _, found := c.items[key]; !found && c.maxEntries > 0 && len(c.items) >= c.maxEntries {
+			c.evictOldest()
+		}
+
+		c.version++
 		c.items[key] = cacheItem{
 			value:      val,
 			expiration: expiration,
+			created:    time.Now().UnixNano(),
+			version:    c.version,
 		}
 	case cacheTypeFile:
-		file, err := os.OpenFile(c.filePath+"/"+key, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0644))
-		if err != nil {
-			return fmt.Errorf("%v: %w", ErrCreatingFile, err)
-		}
-
-		if _, err := file.Write(val); err != nil {
+		if err := c.writeCacheFile(key, newFileHeader(key, expiration), val); err != nil {
 			return err
 		}
 
 		c.cacheFiles[key] = struct{}{}
 	case cacheTypeRedis:
-		if err := c.redisClient.Set(key, val, c.expiration).Err(); err != nil {
+		if err := c.redisClient.Set(c.key(key), val, c.expiration).Err(); err != nil {
 			return err
 		}
 	case cacheTypeMemcache:
+		if len(val) > memcacheMaxValueSize {
+			return c.setChunkedStream(key, bytes.NewReader(val))
+		}
+
 		if err := c.memCacheClient.Set(&memcache.Item{
-			Key:        key,
+			Key:        c.key(key),
 			Value:      val,
-			Expiration: int32(c.expiration.Seconds()),
+			Expiration: c.memcacheExpiration(c.expiration),
+		}); err != nil {
+			return err
+		}
+	case cacheTypeMetaMemcache:
+		if err := c.setMetaCache(key, val); err != nil {
+			return err
+		}
+	case cacheTypeLog:
+		if err := c.logStore.put(key, val, expiration); err != nil {
+			return err
+		}
+	case cacheTypeBolt:
+		if err := c.boltDB.Update(func(tx *bolt.Tx) error {
+			return c.boltPut(tx, key, val, expiration)
 		}); err != nil {
 			return err
 		}
@@ -259,6 +672,12 @@ func (c *cache) set(key string, value interface{}) error {
 
 // This will return boolean if the cache exists and is valid
 func (c *cache) Has(key string) bool {
+	if validateKey(key) != nil {
+		return false
+	}
+
+	c.flushBatch()
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -280,21 +699,24 @@ func (c *cache) has(key string) bool {
 			}
 		}
 	case cacheTypeFile:
-		fileInfo, err := os.Stat(c.filePath + "/" + key)
-		if err != nil {
-			return false
-		}
-
-		if c.expiration > 0 && time.Now().UnixNano() > (c.expiration.Nanoseconds()+fileInfo.ModTime().UnixNano()) {
-			_ = os.Remove(c.filePath + "/" + key)
-			return false
-		}
+		return c.hasFileCache(key)
 	case cacheTypeRedis:
-		if _, err := c.redisClient.Get(key).Result(); err != nil {
+		if n, err := c.redisClient.Exists(c.key(key)).Result(); err != nil || n == 0 {
 			return false
 		}
 	case cacheTypeMemcache:
-		if _, err := c.memCacheClient.Get(key); err != nil {
+		return c.hasMemCache(key)
+	case cacheTypeMetaMemcache:
+		return c.hasMetaCache(key)
+	case cacheTypeLog:
+		if _, found := c.logStore.lookup(key); !found {
+			return false
+		}
+	case cacheTypeBolt:
+		if err := c.boltDB.View(func(tx *bolt.Tx) error {
+			_, _, err := c.boltGet(tx, key)
+			return err
+		}); err != nil {
 			return false
 		}
 	default:
@@ -306,38 +728,58 @@ func (c *cache) has(key string) bool {
 
 // This returns the value in the cache for the given key if its valid. Returns error if cache doesn'interval exist or expired
 func (c *cache) Get(key string) ([]byte, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	switch c.cacheType {
-	case cacheTypeDefault:
-		return c.getDefaultCache(key, false)
-	case cacheTypeFile:
-		return c.getFileCache(key, false)
-	case cacheTypeRedis:
-		return c.getRedisCache(key, false)
-	case cacheTypeMemcache:
-		return c.getMemCache(key, false)
+	if err := validateKey(key); err != nil {
+		return nil, err
 	}
 
-	return nil, ErrCacheNotFound
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	return c.countLookup(c.get(key, false))
 }
 
 // This returns the value in the cache for the given key if it's valid (AND also removes the cache for the given key).
 // Returns error if cache doesn'interval exist or expired
 func (c *cache) Pull(key string) ([]byte, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	c.flushBatch()
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return c.countLookup(c.get(key, true))
+}
+
+// Returns the value for the given key from the selected cache type. Removes current cache depending on second parameter
+func (c *cache) get(key string, removeCurrent bool) ([]byte, error) {
 	switch c.cacheType {
 	case cacheTypeDefault:
-		return c.getDefaultCache(key, true)
+		return c.getDefaultCache(key, removeCurrent)
 	case cacheTypeFile:
-		return c.getFileCache(key, true)
+		return c.getFileCache(key, removeCurrent)
 	case cacheTypeRedis:
-		return c.getRedisCache(key, true)
+		value, err := c.getRedisCache(key, removeCurrent)
+		return c.readChunked(key, value, err, removeCurrent)
 	case cacheTypeMemcache:
-		return c.getMemCache(key, true)
+		value, err := c.getMemCache(key, removeCurrent)
+		return c.readChunked(key, value, err, removeCurrent)
+	case cacheTypeMetaMemcache:
+		value, err := c.getMetaCache(key, removeCurrent)
+		return c.readChunked(key, value, err, removeCurrent)
+	case cacheTypeLog:
+		return c.getLogCache(key, removeCurrent)
+	case cacheTypeBolt:
+		return c.getBoltCache(key, removeCurrent)
 	}
 
 	return nil, ErrCacheNotFound
@@ -345,77 +787,194 @@ func (c *cache) Pull(key string) ([]byte, error) {
 
 // Returns value from memory cache for given key. Removes current cache depending on second parameter
 func (c *cache) getDefaultCache(key string, removeCurrent bool) ([]byte, error) {
+	item, err := c.getDefaultCacheItem(key, removeCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items that were stored with HSetFields keep only their fields, which are encoded when they are read
+	if item.fields != nil {
+		return encodeHash(item.fields)
+	}
+
+	return item.value, nil
+}
+
+// Returns the item from memory cache for given key. Removes current cache depending on second parameter
+func (c *cache) getDefaultCacheItem(key string, removeCurrent bool) (cacheItem, error) {
 	item, found := c.items[key]
 	if !found {
-		return nil, ErrCacheNotFound
+		return cacheItem{}, ErrCacheNotFound
 	}
 
 	if item.expiration > 0 {
 		if time.Now().UnixNano() > item.expiration {
 			delete(c.items, key)
-			return nil, ErrCacheExpired
+			return cacheItem{}, ErrCacheExpired
 		}
 	}
 
 	if removeCurrent {
 		delete(c.items, key)
+	} else if c.sliding && item.expiration > 0 {
+		item.expiration = c.expiresAt()
+		c.items[key] = item
 	}
 
-	return item.value, nil
+	return item, nil
 }
 
 // Returns value from file cache for given key. Removes current cache depending on second parameter
 func (c *cache) getFileCache(key string, removeCurrent bool) ([]byte, error) {
-	fileInfo, err := os.Stat(c.filePath + "/" + key)
-	if err != nil {
-		return nil, ErrCacheNotFound
-	}
+	_, value, err := c.getFileCacheWithHeader(key, removeCurrent)
 
-	if c.expiration > 0 && time.Now().UnixNano() > (c.expiration.Nanoseconds()+fileInfo.ModTime().UnixNano()) {
-		_ = os.Remove(c.filePath + "/" + key)
-		return nil, ErrCacheExpired
+	return value, err
+}
+
+// Returns the header and the value of the cache file like getFileCache
+func (c *cache) getFileCacheWithHeader(key string, removeCurrent bool) (fileHeader, []byte, error) {
+	if c.sliding && !removeCurrent {
+		unlock, err := c.lockEntry(key)
+		if err != nil {
+			return fileHeader{}, nil, err
+		}
+		defer unlock()
 	}
 
-	value, err := ioutil.ReadFile(c.filePath + "/" + key)
+	header, value, err := c.readValidCacheFile(key)
 	if err != nil {
-		return nil, ErrCacheNotFound
+		return header, nil, err
 	}
 
 	if removeCurrent {
-		_ = os.Remove(c.filePath + "/" + key)
+		_ = c.removeCacheFile(key)
+	} else if c.sliding && header.Expires > 0 {
+		header.Expires = c.expiresAt()
+		_ = c.writeCacheFile(key, header, value)
 	}
 
-	return value, nil
+	c.recordAccess(key)
+
+	return header, value, nil
 }
 
 // Returns value from redis cache for given key. Removes current cache depending on second parameter
 func (c *cache) getRedisCache(key string, removeCurrent bool) ([]byte, error) {
-	val, err := c.redisClient.Get(key).Result()
+	if c.sliding && !removeCurrent && c.expiration > defaultExpiration {
+		val, err := redisGetAndSlide.Run(c.redisClient, []string{c.key(key)}, c.expiration.Milliseconds()).String()
+		if err != nil {
+			return c.getRedisHash(key, removeCurrent, err)
+		}
+
+		return []byte(val), nil
+	}
+
+	val, err := c.redisClient.Get(c.key(key)).Result()
 	if err != nil {
-		return nil, ErrCacheNotFound
+		return c.getRedisHash(key, removeCurrent, err)
 	}
 
 	if removeCurrent {
-		_ = c.redisClient.Del(key)
+		_ = c.redisClient.Del(c.key(key))
 	}
 
 	return []byte(val), nil
 }
 
+// Probes the key with a meta get without flags, which doesn't transfer the value. Servers older than memcached 1.6
+// don't support the meta protocol, so the value is fetched instead
+func (c *cache) hasMemCache(key string) bool {
+	result, err := c.metaClient.get(c.key(key))
+	if err == errMetaUnknownCommand {
+		_, err = c.memCacheClient.Get(c.key(key))
+		return err == nil
+	}
+
+	return err == nil && result.status == metaStatusHit
+}
+
 // Returns value from redis cache for given key. Removes current cache depending on second parameter
 func (c *cache) getMemCache(key string, removeCurrent bool) ([]byte, error) {
-	val, err := c.memCacheClient.Get(key)
+	val, err := c.memCacheClient.Get(c.key(key))
 	if err != nil {
 		return nil, ErrCacheNotFound
 	}
 
 	if removeCurrent {
-		_ = c.memCacheClient.Delete(key)
+		_ = c.memCacheClient.Delete(c.key(key))
+	} else if c.sliding && c.expiration > defaultExpiration {
+		_ = c.memCacheClient.Touch(c.key(key), c.memcacheExpiration(c.expiration))
 	}
 
 	return val.Value, nil
 }
 
+// Locks the cache for reading and returns the function to unlock it. A sliding memory or file cache updates the
+// expiration when reading, so it takes the write lock
+func (c *cache) readLock() func() {
+	if c.sliding && (c.cacheType == cacheTypeDefault || c.cacheType == cacheTypeFile || c.cacheType == cacheTypeLog) {
+		c.mu.Lock()
+		return c.mu.Unlock
+	}
+
+	c.mu.RLock()
+	return c.mu.RUnlock
+}
+
+// Returns the unix time in nanoseconds at which an item stored now expires. 0 means it never expires
+func (c *cache) expiresAt() int64 {
+	if c.expiration > defaultExpiration {
+		return time.Now().Add(c.expiration).UnixNano()
+	}
+
+	return 0
+}
+
+// Returns the key as stored in redis or memcache, including the configured prefix
+func (c *cache) key(key string) string {
+	if c.cacheType == cacheTypeMemcache || c.cacheType == cacheTypeMetaMemcache {
+		return c.memcacheKey(key)
+	}
+
+	return c.prefix + key
+}
+
+// Removes all the keys from the selected redis database. If a prefix is configured only the keys with that prefix are removed
+func (c *cache) flushRedis() {
+	if c.prefix == "" {
+		c.redisClient.FlushDB()
+		return
+	}
+
+	iter := c.redisClient.Scan(0, c.prefix+"*", 100).Iterator()
+	for iter.Next() {
+		c.redisClient.Del(iter.Val())
+	}
+}
+
+// Removes expired items from the memory cache. If none of them expired, the item that was stored first is removed
+func (c *cache) evictOldest() {
+	var oldestKey string
+	var oldest int64
+	now := time.Now().UnixNano()
+
+	for key, item := range c.items {
+		if item.expiration > 0 && now > item.expiration {
+			delete(c.items, key)
+			continue
+		}
+
+		if oldestKey == "" || item.created < oldest {
+			oldestKey = key
+			oldest = item.created
+		}
+	}
+
+	if len(c.items) >= c.maxEntries {
+		delete(c.items, oldestKey)
+	}
+}
+
 // This is a job that will execute each duration of the cache and clears the expired cache
 func (c *cache) cleanExpiredCache() {
 	if c.cleaner == nil {
@@ -426,32 +985,55 @@ func (c *cache) cleanExpiredCache() {
 		return
 	}
 
-	runtime.SetFinalizer(c.cleaner, stopCleaningRoutine)
+	cleaner := c.cleaner
+	runtime.SetFinalizer(cleaner, stopCleaningRoutine)
 
 	go func() {
 		for {
 			select {
-			case <-c.cleaner.interval.C:
+			case <-cleaner.interval.C:
 				switch c.cacheType {
 				case cacheTypeDefault:
 					for key, _ := range c.items {
 						c.has(key)
 					}
 				case cacheTypeFile:
-					for key, _ := range c.cacheFiles {
-						go c.has(key)
+					c.mu.Lock()
+					for key := range c.cacheFiles {
+						c.removeExpiredCacheFile(key)
 					}
+					c.mu.Unlock()
 				}
 
-				c.cleaner.interval.Reset(c.expiration)
-			case <-c.cleaner.stop:
-				c.cleaner.interval.Stop()
+				cleaner.interval.Reset(c.expiration)
+			case <-cleaner.stop:
+				cleaner.interval.Stop()
+				return
 			}
 
 		}
 	}()
 }
 
+// This is a job that executes the given job each interval
+func (c *cache) runJanitor(interval time.Duration, job func()) {
+	janitor := c.janitor
+	runtime.SetFinalizer(janitor, stopCleaningRoutine)
+
+	go func() {
+		for {
+			select {
+			case <-janitor.interval.C:
+				job()
+				janitor.interval.Reset(interval)
+			case <-janitor.stop:
+				janitor.interval.Stop()
+				return
+			}
+		}
+	}()
+}
+
 // go routine is stopped stop is set to true
 func stopCleaningRoutine(cleaner *cacheCleaner) {
 	cleaner.stop <- true
@@ -0,0 +1,490 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltCacheSetSuccessWithString(t *testing.T) {
+	key := "cache_key"
+	val := "value"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheSetSuccessWithInt(t *testing.T) {
+	key := "cache_key"
+	val := 1
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(int)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheSetSuccessWithBoolean(t *testing.T) {
+	key := "cache_key"
+	val := true
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(bool)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheSetSuccessWithStruct_set(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(testItem)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheAddSuccessWithString(t *testing.T) {
+	key := "cache_key"
+	val := "value"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheAddSuccessWithInt(t *testing.T) {
+	key := "cache_key"
+	val := 1
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(int)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheAddSuccessWithBoolean(t *testing.T) {
+	key := "cache_key"
+	val := true
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(bool)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheAddSuccessWithStruct(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(testItem)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestBoltCacheAddErrorCacheAlreadyExists(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.Error(t, err)
+}
+
+func TestBoltCachePullSuccessWithStruct(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	_, err = cache.Pull(key)
+	assert.NoError(t, err)
+	assert.False(t, cache.Has(key))
+}
+
+func TestBoltCacheExpired(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	time.Sleep(5 * time.Second)
+	_, err = cache.Pull(key)
+	assert.Error(t, err)
+}
+
+func TestBoltCacheMultiSuccess(t *testing.T) {
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestBoltCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestBoltCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestBoltCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestBoltCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestBoltCacheCompareAndSwapErrorConflictWhenValueWasRestored(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	cache.Delete(key)
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestBoltCacheCompareAndSwapErrorConflictAfterFlush(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	cache.Flush()
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestBoltCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 5*time.Second)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 5*time.Second && ttl <= time.Minute)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestBoltCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewBoltCache(3 * time.Second, "cache/bolt/"+t.Name()+".db", WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestBoltCacheNamespacesAreSeparate(t *testing.T) {
+	path := "cache/bolt/" + t.Name() + ".db"
+	first, err := NewBoltCache(5 * time.Second, path, WithNamespace("first"))
+	assert.NoError(t, err)
+	assert.NoError(t, first.Set("cache_key", "first value"))
+
+	second, err := NewBoltCache(5 * time.Second, path, WithNamespace("second"))
+	assert.NoError(t, err)
+	assert.False(t, second.Has("cache_key"))
+	assert.NoError(t, second.Set("cache_key", "second value"))
+
+	value, err := first.Get("cache_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"first value"`), value)
+
+	assert.NoError(t, first.Close())
+	assert.NoError(t, second.Close())
+
+	first, err = NewBoltCache(5 * time.Second, path, WithNamespace("first"))
+	assert.NoError(t, err)
+	value, err = first.Get("cache_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"first value"`), value)
+	assert.NoError(t, first.Close())
+}
+
+func TestBoltCacheCloseKeepsSharedDatabaseOpen(t *testing.T) {
+	path := "cache/bolt/" + t.Name() + ".db"
+	first, err := NewBoltCache(5 * time.Second, path)
+	assert.NoError(t, err)
+
+	second, err := NewBoltCache(5 * time.Second, path)
+	assert.NoError(t, err)
+	assert.NoError(t, first.Close())
+
+	assert.NoError(t, second.Set("cache_key", "value"))
+	value, err := second.Get("cache_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"value"`), value)
+	assert.NoError(t, second.Close())
+}
+
+func TestBoltCacheRemovesExpiredKeysInBatches(t *testing.T) {
+	c, err := NewBoltCache(time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	items := make(map[string]interface{})
+	for i := 0; i < boltCleanupBatchSize*2+1; i++ {
+		items[fmt.Sprintf("key_%d", i)] = i
+	}
+	assert.NoError(t, c.SetMulti(items))
+
+	time.Sleep(1100 * time.Millisecond)
+	c.(*cache).removeExpiredBoltKeys()
+
+	err = c.(*cache).boltDB.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, 0, tx.Bucket(c.(*cache).boltBucket).Stats().KeyN)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestBoltCacheDeletePrefix(t *testing.T) {
+	cache, err := NewBoltCache(5 * time.Second, "cache/bolt/"+t.Name()+".db")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"user:1":   1,
+		"user:2":   2,
+		"session:1": 1,
+	})
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("user:1"))
+	assert.False(t, cache.Has("user:2"))
+	assert.True(t, cache.Has("session:1"))
+}
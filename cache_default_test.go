@@ -2,6 +2,8 @@ package cache
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -223,3 +225,400 @@ func TestDefaultCacheExpired(t *testing.T) {
 	_, err = cache.Pull(key)
 	assert.Error(t, err)
 }
+
+func TestDefaultCacheMaxEntriesEvictsOldest(t *testing.T) {
+	cache, err := NewDefaultCache(5*time.Second, WithMaxEntries(2))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cache.Set("first", 1))
+	assert.NoError(t, cache.Set("second", 2))
+	assert.NoError(t, cache.Set("third", 3))
+
+	assert.False(t, cache.Has("first"))
+	assert.True(t, cache.Has("second"))
+	assert.True(t, cache.Has("third"))
+}
+
+func TestDefaultCacheMultiSuccess(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestDefaultCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestDefaultCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestDefaultCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestDefaultCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestDefaultCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 5*time.Second)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 5*time.Second && ttl <= time.Minute)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestDefaultCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(3 * time.Second, WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestDefaultCacheSlidingExpirationTTLDoesNotSlide(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(3 * time.Second, WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(1 * time.Second)
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl <= 2 * time.Second)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl <= 2 * time.Second)
+}
+
+func TestDefaultCacheStatsCountsHitsAndMisses(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set("cache_key", "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Get("cache_key")
+	assert.NoError(t, err)
+	_, err = cache.Get("missing_key")
+	assert.Error(t, err)
+	_, err = cache.Pull("cache_key")
+	assert.NoError(t, err)
+
+	assert.Equal(t, Stats{Hits: 2, Misses: 1}, cache.Stats())
+}
+
+func TestDefaultCacheDeletePrefix(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"user:1":   1,
+		"user:2":   2,
+		"session:1": 1,
+	})
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("user:1"))
+	assert.False(t, cache.Has("user:2"))
+	assert.True(t, cache.Has("session:1"))
+}
+
+func TestDefaultCacheGetMapped(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set("cache_key", "value")
+	assert.NoError(t, err)
+
+	value, err := cache.GetMapped("cache_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"value"`), value.Bytes())
+	assert.NoError(t, value.Release())
+
+	_, err = cache.GetMapped("missing_key")
+	assert.Error(t, err)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1}, cache.Stats())
+}
+
+func TestDefaultCacheSetStreamAndGetStream(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.SetStream("cache_key", strings.NewReader("raw value"))
+	assert.NoError(t, err)
+
+	stream, err := cache.GetStream("cache_key")
+	assert.NoError(t, err)
+	content, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, "raw value", string(content))
+
+	_, err = cache.GetStream("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestDefaultCacheErrorInvalidKey(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set("", "value")
+	assert.Equal(t, ErrInvalidKey, err)
+	assert.False(t, cache.Has(""))
+
+	_, err = cache.Get("")
+	assert.Equal(t, ErrInvalidKey, err)
+
+	_, err = cache.Increment("", 1)
+	assert.Equal(t, ErrInvalidKey, err)
+
+	err = cache.SetMulti(map[string]interface{}{"": 1, "cache_key": 2})
+	assert.Error(t, err)
+	assert.Equal(t, ErrInvalidKey, err.(MultiError)[""])
+	assert.True(t, cache.Has("cache_key"))
+}
+
+func TestDefaultCacheHashFields(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value", "count": 1})
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"count": 2})
+	assert.NoError(t, err)
+
+	value, err := cache.HGetField(key, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	fields, err := cache.HGetAll(key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte(`"value"`), "count": []byte("2")}, fields)
+
+	document, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "value", "count": 2}`, string(document))
+
+	_, err = cache.HGetField(key, "missing")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	_, err = cache.HGetAll("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestDefaultCacheHashFieldsExpire(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewDefaultCache(1 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	_, err = cache.HGetField(key, "name")
+	assert.Error(t, err)
+}
+
+func TestDefaultCacheHashFieldsGetMulti(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+
+	err = cache.Set("cache_key", "value")
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{key, "cache_key", "missing_key"})
+	assert.Equal(t, MultiError{"missing_key": ErrCacheNotFound}, err)
+	assert.JSONEq(t, `{"name": "value"}`, string(values[key]))
+	assert.Equal(t, []byte(`"value"`), values["cache_key"])
+}
+
+func TestDefaultCacheHashFieldsErrorNotHash(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.Equal(t, ErrNotHash, err)
+
+	_, err = cache.HGetAll(key)
+	assert.Equal(t, ErrNotHash, err)
+}
+
+func TestDefaultCacheHashFieldsUpdateTheStoredFields(t *testing.T) {
+	key := "hash_key"
+	c, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	err = c.Set(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+
+	err = c.HSetFields(key, map[string]interface{}{"count": 1})
+	assert.NoError(t, err)
+
+	item := c.(*cache).items[key]
+	assert.Nil(t, item.value)
+	assert.Equal(t, map[string][]byte{"name": []byte(`"value"`), "count": []byte("1")}, item.fields)
+
+	document, err := c.Get(key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "value", "count": 1}`, string(document))
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = c.HGetAll(key)
+	assert.Equal(t, ErrNotHash, err)
+}
+
+func TestDefaultCacheSetAsyncWritesImmediately(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	writer := cache.(BatchWriter)
+
+	future := writer.SetAsync(key, "value")
+	select {
+	case <-future.Done():
+	default:
+		t.Fatal("future is not done")
+	}
+	assert.NoError(t, future.Wait())
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"value"`), value)
+
+	assert.NoError(t, writer.DeleteAsync(key).Wait())
+	assert.False(t, cache.Has(key))
+	assert.NoError(t, writer.Sync())
+	assert.Equal(t, ErrInvalidKey, writer.SetAsync("", "value").Wait())
+}
@@ -2,6 +2,12 @@ package cache
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -219,3 +225,624 @@ func TestFileCacheExpired(t *testing.T) {
 	_, err = cache.Pull(key)
 	assert.Error(t, err)
 }
+
+func TestFileCacheMultiSuccess(t *testing.T) {
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestFileCacheMultiLimitsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning, done int
+
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = "multi_key_" + strconv.Itoa(i)
+	}
+
+	forEachFile(keys, func(key string) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		done++
+		mu.Unlock()
+	})
+
+	assert.Equal(t, len(keys), done)
+	assert.True(t, maxRunning <= fileMultiConcurrency)
+
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	items := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		items[key] = key
+	}
+
+	assert.NoError(t, cache.SetMulti(items))
+
+	values, err := cache.GetMulti(keys)
+	assert.NoError(t, err)
+	assert.Len(t, values, len(keys))
+
+	assert.NoError(t, cache.DeleteMulti(keys))
+}
+
+func TestFileCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestFileCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestFileCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestFileCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestFileCacheCompareAndSwapErrorConflictWhenValueWasRestored(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	cache.Delete(key)
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestFileCacheCompareAndSwapErrorConflictAfterIncrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	_, err = cache.Increment(key, 1)
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, 10)
+	assert.Equal(t, ErrCASConflict, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+}
+
+func TestFileCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 5*time.Second)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 5*time.Second && ttl <= time.Minute)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestFileCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewFileCache(3 * time.Second, "cache", WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestFileCacheUnsafeKeysStayInDirectory(t *testing.T) {
+	c, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	fileCache := c.(*cache)
+
+	for _, key := range []string{"../../escaped_key", "user/42", "/absolute"} {
+		err = fileCache.Set(key, "value")
+		assert.NoError(t, err)
+
+		path := fileCache.cacheFilePath(key)
+		assert.True(t, strings.HasPrefix(path, "cache"+string(filepath.Separator)))
+		assert.FileExists(t, path)
+
+		value, err := fileCache.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`"value"`), value)
+	}
+
+	_, err = os.Stat("../escaped_key")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileCacheAtomicWriteLeavesNoTemporaryFiles(t *testing.T) {
+	for _, durability := range []Durability{DurabilityNone, DurabilityFile, DurabilityDirectory} {
+		cache, err := NewFileCache(5 * time.Second, "cache", WithDurability(durability))
+		assert.NoError(t, err)
+
+		err = cache.Set("cache_key", "value")
+		assert.NoError(t, err)
+
+		err = cache.Set("cache_key", "new value")
+		assert.NoError(t, err)
+
+		value, err := cache.Get("cache_key")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`"new value"`), value)
+	}
+
+	err := filepath.Walk("cache", func(path string, info os.FileInfo, err error) error {
+		assert.False(t, strings.HasPrefix(info.Name(), tempFilePrefix), path)
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+func TestFileCacheRebuildsIndexAfterRestart(t *testing.T) {
+	c, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = c.Set("restart_key", "value")
+	assert.NoError(t, err)
+
+	c, err = NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	assert.Contains(t, c.(*cache).cacheFiles, "restart_key")
+
+	header, err := c.(*cache).readCacheFileHeader("restart_key")
+	assert.NoError(t, err)
+	assert.Equal(t, fileFormatVersion, header.Format)
+	assert.Equal(t, "restart_key", header.Key)
+
+	c.Flush()
+	assert.False(t, c.Has("restart_key"))
+}
+
+func TestFileCacheIncrementIsAtomicAcrossInstances(t *testing.T) {
+	key := "shared_counter_key"
+	first, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	second, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	first.Delete(key)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, cache := range []Cache{first, second} {
+			wg.Add(1)
+			go func(cache Cache) {
+				defer wg.Done()
+				_, err := cache.Increment(key, 1)
+				assert.NoError(t, err)
+			}(cache)
+		}
+	}
+	wg.Wait()
+
+	value, err := first.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("100"), value)
+}
+
+func TestFileCacheQuotaEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := NewFileCache(5 * time.Second, "cache/quota", WithMaxFiles(2))
+	assert.NoError(t, err)
+	c.Flush()
+
+	assert.NoError(t, c.Set("first", 1))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, c.Set("second", 2))
+	time.Sleep(10 * time.Millisecond)
+	_, err = c.Get("first")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set("third", 3))
+
+	c.(*cache).enforceFileQuota()
+	assert.True(t, c.Has("first"))
+	assert.False(t, c.Has("second"))
+	assert.True(t, c.Has("third"))
+}
+
+func TestFileCacheQuotaIsEnforcedAfterRestart(t *testing.T) {
+	c, err := NewFileCache(5 * time.Second, "cache/quota")
+	assert.NoError(t, err)
+	c.Flush()
+
+	assert.NoError(t, c.Set("first", 1))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, c.Set("second", 2))
+
+	c, err = NewFileCache(5 * time.Second, "cache/quota", WithMaxFiles(1), WithEvictionPolicy(EvictOldest))
+	assert.NoError(t, err)
+	assert.False(t, c.Has("first"))
+	assert.True(t, c.Has("second"))
+}
+
+func TestFileCacheCorruptedEntryIsQuarantined(t *testing.T) {
+	key := "corrupted_key"
+	dir := t.TempDir()
+	c, err := NewFileCache(5 * time.Second, dir, WithQuarantine())
+	assert.NoError(t, err)
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+
+	path := c.(*cache).cacheFilePath(key)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	content[len(content)-2] = 'X'
+	err = ioutil.WriteFile(path, content, 0644)
+	assert.NoError(t, err)
+
+	_, err = c.Get(key)
+	assert.Equal(t, ErrCacheCorrupted, err)
+	assert.Equal(t, uint64(1), c.Stats().Corrupted)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	quarantined, err := filepath.Glob(filepath.Join(dir, quarantineDirName, filepath.Base(path)+"-*"))
+	assert.NoError(t, err)
+	assert.Len(t, quarantined, 1)
+}
+
+func TestFileCacheDeletePrefix(t *testing.T) {
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"user:1":   1,
+		"user:2":   2,
+		"session:1": 1,
+	})
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("user:1"))
+	assert.False(t, cache.Has("user:2"))
+	assert.True(t, cache.Has("session:1"))
+}
+
+func TestFileCacheFileAndDirMode(t *testing.T) {
+	key := "secret_key"
+	path := "cache/perm/" + t.Name()
+	os.RemoveAll(path)
+	c, err := NewFileCache(5 * time.Second, path, WithFileMode(0600), WithDirMode(0700))
+	assert.NoError(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+
+	file := c.(*cache).cacheFilePath(key)
+	info, err = os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Dir(file))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
+
+func TestFileCacheErrorMissingDirectory(t *testing.T) {
+	path := "cache/perm/" + t.Name()
+	os.RemoveAll(path)
+
+	_, err := NewFileCache(5 * time.Second, path, WithCreateDir(false))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrCreatingFile.Error())
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileCacheErrorPathIsNotADirectory(t *testing.T) {
+	path := "cache/perm/" + t.Name()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	err := ioutil.WriteFile(path, []byte("file"), 0644)
+	assert.NoError(t, err)
+
+	_, err = NewFileCache(5 * time.Second, path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrCreatingFile.Error())
+}
+
+func TestFileCacheGetMapped(t *testing.T) {
+	key := "mapped_key"
+	val := strings.Repeat("report", 1024)
+	c, err := NewFileCache(5 * time.Second, "cache", WithMmapThreshold(1024))
+	assert.NoError(t, err)
+
+	err = c.Set(key, val)
+	assert.NoError(t, err)
+
+	value, err := c.GetMapped(key)
+	assert.NoError(t, err)
+	assert.NotNil(t, value.mapping)
+
+	c.Delete(key)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value.Bytes(), cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+
+	buf := make([]byte, 8)
+	n, err := value.ReadAt(buf, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "reportre", string(buf))
+
+	assert.NoError(t, value.Release())
+	assert.NoError(t, value.Release())
+	assert.Equal(t, 0, value.Len())
+
+	_, err = c.GetMapped(key)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestFileCacheGetMappedSmallValueIsRead(t *testing.T) {
+	key := "small_key"
+	c, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+
+	value, err := c.GetMapped(key)
+	assert.NoError(t, err)
+	assert.Nil(t, value.mapping)
+	assert.Equal(t, []byte(`"value"`), value.Bytes())
+	assert.NoError(t, value.Release())
+}
+
+func TestFileCacheSetStreamAndGetStream(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 100000)
+	c, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = c.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+
+	stream, err := c.GetStream(key)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, val, string(content))
+
+	value, err := c.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(value))
+
+	_, err = c.GetStream("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestFileCacheGetStreamErrorCorrupted(t *testing.T) {
+	key := "stream_key"
+	c, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = c.SetStream(key, strings.NewReader("value"))
+	assert.NoError(t, err)
+
+	path := c.(*cache).cacheFilePath(key)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	content[len(content)-1] = 'X'
+	err = ioutil.WriteFile(path, content, 0644)
+	assert.NoError(t, err)
+
+	stream, err := c.GetStream(key)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(stream)
+	assert.Equal(t, ErrCacheCorrupted, err)
+	assert.NoError(t, stream.Close())
+}
+
+func TestFileCacheHashFields(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value", "count": 1})
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"count": 2})
+	assert.NoError(t, err)
+
+	value, err := cache.HGetField(key, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	fields, err := cache.HGetAll(key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte(`"value"`), "count": []byte("2")}, fields)
+
+	document, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "value", "count": 2}`, string(document))
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 4*time.Second)
+
+	_, err = cache.HGetField(key, "missing")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestFileCacheHashFieldsErrorNotHash(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, []string{"value"})
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.Equal(t, ErrNotHash, err)
+}
+
+func TestFileCacheHasSeesRewriteByOtherInstance(t *testing.T) {
+	key := "cache_key"
+	first, err := NewFileCache(5 * time.Second, "cache")
+	assert.NoError(t, err)
+	second, err := NewFileCache(1 * time.Second, "cache")
+	assert.NoError(t, err)
+
+	err = first.Set(key, "value")
+	assert.NoError(t, err)
+	assert.True(t, first.Has(key))
+
+	err = second.Set(key, "value")
+	assert.NoError(t, err)
+	assert.True(t, first.Has(key))
+
+	time.Sleep(2 * time.Second)
+	assert.False(t, first.Has(key))
+
+	err = second.Set(key, "value")
+	assert.NoError(t, err)
+	assert.True(t, first.Has(key))
+
+	second.Delete(key)
+	assert.False(t, first.Has(key))
+}
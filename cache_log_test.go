@@ -0,0 +1,589 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogCacheSetSuccessWithString(t *testing.T) {
+	key := "cache_key"
+	val := "value"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheSetSuccessWithInt(t *testing.T) {
+	key := "cache_key"
+	val := 1
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(int)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheSetSuccessWithBoolean(t *testing.T) {
+	key := "cache_key"
+	val := true
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(bool)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheSetSuccessWithStruct_set(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(testItem)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheAddSuccessWithString(t *testing.T) {
+	key := "cache_key"
+	val := "value"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheAddSuccessWithInt(t *testing.T) {
+	key := "cache_key"
+	val := 1
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(int)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheAddSuccessWithBoolean(t *testing.T) {
+	key := "cache_key"
+	val := true
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(bool)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheAddSuccessWithStruct(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(testItem)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+}
+
+func TestLogCacheAddErrorCacheAlreadyExists(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.Error(t, err)
+}
+
+func TestLogCachePullSuccessWithStruct(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	_, err = cache.Pull(key)
+	assert.NoError(t, err)
+	assert.False(t, cache.Has(key))
+}
+
+func TestLogCacheExpired(t *testing.T) {
+	key := "cache_key"
+	val := testItem{
+		Key:   "Rohit",
+		Value: "Subedi",
+	}
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	time.Sleep(5 * time.Second)
+	_, err = cache.Pull(key)
+	assert.Error(t, err)
+}
+
+func TestLogCacheMultiSuccess(t *testing.T) {
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestLogCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestLogCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestLogCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestLogCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestLogCacheCompareAndSwapErrorConflictWhenValueWasRestored(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	cache.Delete(key)
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestLogCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 5*time.Second)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 5*time.Second && ttl <= time.Minute)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestLogCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewLogCache(3 * time.Second, "cache/log/"+t.Name(), WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestLogCacheTouchAppendsOnlyTheExpiration(t *testing.T) {
+	key := "cache_key"
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir, WithSlidingExpiration())
+	assert.NoError(t, err)
+	c.Flush()
+
+	assert.NoError(t, c.Set(key, strings.Repeat("x", 1024)))
+
+	_, version, err := c.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	store := c.(*cache).logStore
+	size := store.activeSize
+
+	assert.NoError(t, c.Touch(key, time.Minute))
+	assert.Equal(t, size+int64(logRecordHeaderSize+len(key)), store.activeSize)
+
+	_, err = c.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, size+2*int64(logRecordHeaderSize+len(key)), store.activeSize)
+
+	assert.NoError(t, c.CompareAndSwap(key, version, "new value"))
+	assert.NoError(t, c.Close())
+
+	c, err = NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+
+	value, err := c.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestLogCacheCompactionKeepsTouchedExpiration(t *testing.T) {
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir, WithSegmentSize(64<<10))
+	assert.NoError(t, err)
+	c.Flush()
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 2048; i++ {
+		assert.NoError(t, c.Set("key_"+strconv.Itoa(i%16), value))
+	}
+
+	for i := 0; i < 16; i++ {
+		assert.NoError(t, c.Touch("key_"+strconv.Itoa(i), time.Minute))
+	}
+
+	assert.NoError(t, c.(*cache).logStore.compact())
+	assert.NoError(t, c.Close())
+
+	hints, err := filepath.Glob(filepath.Join(dir, "*"+logHintExt))
+	assert.NoError(t, err)
+	for _, hint := range hints {
+		assert.NoError(t, os.Remove(hint))
+	}
+
+	c, err = NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+	for i := 0; i < 16; i++ {
+		ttl, err := c.TTL("key_" + strconv.Itoa(i))
+		assert.NoError(t, err)
+		assert.True(t, ttl > 5*time.Second)
+	}
+}
+
+func TestLogCacheCompareAndSwapAfterCompaction(t *testing.T) {
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir, WithSegmentSize(64<<10))
+	assert.NoError(t, err)
+	c.Flush()
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 2048; i++ {
+		assert.NoError(t, c.Set("key_"+strconv.Itoa(i%16), value))
+	}
+
+	_, version, err := c.GetWithVersion("key_0")
+	assert.NoError(t, err)
+
+	store := c.(*cache).logStore
+	assert.NoError(t, store.compact())
+	assert.Equal(t, store.liveBytes, store.totalBytes)
+
+	assert.NoError(t, c.CompareAndSwap("key_0", version, "new value"))
+}
+
+func TestLogCacheReopenReusesEmptySegment(t *testing.T) {
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+	c.Flush()
+	assert.NoError(t, c.Set("first", 1))
+	assert.NoError(t, c.Close())
+
+	for i := 0; i < 3; i++ {
+		c, err = NewLogCache(5 * time.Second, dir)
+		assert.NoError(t, err)
+		assert.True(t, c.Has("first"))
+		assert.NoError(t, c.Close())
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+logDataExt))
+	assert.NoError(t, err)
+	assert.Len(t, segments, 2)
+}
+
+func TestLogCacheRecoversIndexAfterRestart(t *testing.T) {
+	c, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+	c.Flush()
+
+	assert.NoError(t, c.Set("first", 1))
+	assert.NoError(t, c.Set("second", 2))
+	assert.NoError(t, c.Set("first", 3))
+	c.Delete("second")
+
+	c, err = NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	value, err := c.Get("first")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+	assert.False(t, c.Has("second"))
+}
+
+func TestLogCacheTruncatesTornTailRecord(t *testing.T) {
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+	c.Flush()
+
+	assert.NoError(t, c.Set("first", 1))
+	assert.NoError(t, c.Set("second", 2))
+
+	store := c.(*cache).logStore
+	path := store.segmentPath(store.activeID, logDataExt)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(path, info.Size()-1))
+
+	c, err = NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+	assert.True(t, c.Has("first"))
+	assert.False(t, c.Has("second"))
+
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(logRecordHeaderSize+len("first")+len("1")), info.Size())
+}
+
+func TestLogCacheCompactionDropsDeadRecordsAndWritesHint(t *testing.T) {
+	dir := "cache/log/" + t.Name()
+	c, err := NewLogCache(5 * time.Second, dir, WithSegmentSize(64<<10))
+	assert.NoError(t, err)
+	c.Flush()
+
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 2048; i++ {
+		assert.NoError(t, c.Set("key_"+strconv.Itoa(i%16), value))
+	}
+
+	store := c.(*cache).logStore
+	assert.NoError(t, store.compact())
+	assert.Len(t, store.segments, 2)
+	assert.Equal(t, store.liveBytes, store.totalBytes)
+
+	hints, err := filepath.Glob(filepath.Join(dir, "*"+logHintExt))
+	assert.NoError(t, err)
+	assert.Len(t, hints, 1)
+
+	c, err = NewLogCache(5 * time.Second, dir)
+	assert.NoError(t, err)
+	for i := 0; i < 16; i++ {
+		assert.True(t, c.Has("key_"+strconv.Itoa(i)))
+	}
+}
+
+func TestLogCacheDeletePrefix(t *testing.T) {
+	cache, err := NewLogCache(5 * time.Second, "cache/log/"+t.Name())
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"user:1":   1,
+		"user:2":   2,
+		"session:1": 1,
+	})
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("user:1"))
+	assert.False(t, cache.Has("user:2"))
+	assert.True(t, cache.Has("session:1"))
+}
@@ -0,0 +1,668 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMetaServer is an in-memory server for the subset of the memcache meta protocol that the meta cache uses
+type fakeMetaServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	items    map[string]*fakeMetaItem
+	cas      uint64
+	down     int32
+}
+
+type fakeMetaItem struct {
+	value   []byte
+	expires time.Time
+	cas     uint64
+	stale   bool
+	winSent bool
+}
+
+func newFakeMetaServer(t *testing.T) *fakeMetaServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeMetaServer{listener: listener, items: make(map[string]*fakeMetaItem)}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeMetaServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeMetaServer) close() {
+	_ = s.listener.Close()
+}
+
+// Makes the server close every connection without answering, or answer again
+func (s *fakeMetaServer) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&s.down, 1)
+	} else {
+		atomic.StoreInt32(&s.down, 0)
+	}
+}
+
+func (s *fakeMetaServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeMetaServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || atomic.LoadInt32(&s.down) == 1 {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var value []byte
+		if fields[0] == "ms" {
+			size, _ := strconv.Atoi(fields[2])
+			value = make([]byte, size+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return
+			}
+			value = value[:size]
+			fields = append(fields[:2], fields[3:]...)
+		}
+
+		s.mu.Lock()
+		s.execute(w, fields, value)
+		s.mu.Unlock()
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeMetaServer) execute(w *bufio.Writer, fields []string, value []byte) {
+	switch fields[0] {
+	case "mn":
+		w.WriteString("MN\r\n")
+		return
+	case "version":
+		w.WriteString("VERSION 1.6.21\r\n")
+		return
+	case "flush_all":
+		s.items = make(map[string]*fakeMetaItem)
+		w.WriteString("OK\r\n")
+		return
+	}
+
+	key := fields[1]
+	flags := make(map[byte]string)
+	for _, flag := range fields[2:] {
+		flags[flag[0]] = flag[1:]
+	}
+
+	item := s.items[key]
+	if item != nil && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(s.items, key)
+		item = nil
+	}
+
+	switch fields[0] {
+	case "mg":
+		s.metaGet(w, key, item, flags)
+	case "ms":
+		if token, found := flags['C']; found {
+			if item == nil {
+				w.WriteString("NF\r\n")
+				return
+			}
+
+			if strconv.FormatUint(item.cas, 10) != token {
+				w.WriteString("EX\r\n")
+				return
+			}
+		}
+
+		if flags['M'] == "E" && item != nil {
+			w.WriteString("NS\r\n")
+			return
+		}
+
+		s.items[key] = &fakeMetaItem{value: value, expires: fakeMetaExpires(flags['T']), cas: s.nextCAS()}
+		w.WriteString("HD\r\n")
+	case "md":
+		if item == nil {
+			w.WriteString("NF\r\n")
+			return
+		}
+
+		if token, found := flags['C']; found && strconv.FormatUint(item.cas, 10) != token {
+			w.WriteString("EX\r\n")
+			return
+		}
+
+		if _, found := flags['I']; found {
+			item.stale = true
+			item.winSent = false
+			item.cas = s.nextCAS()
+		} else {
+			delete(s.items, key)
+		}
+
+		w.WriteString("HD\r\n")
+	case "ma":
+		s.metaArithmetic(w, key, item, flags)
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+}
+
+// Like memcached, the first mg of a stale item wins whether or not it asked for a lease
+func (s *fakeMetaServer) metaGet(w *bufio.Writer, key string, item *fakeMetaItem, flags map[byte]string) {
+	var ret []string
+
+	if item == nil {
+		ttl, found := flags['N']
+		if !found {
+			if _, quiet := flags['q']; !quiet {
+				w.WriteString("EN\r\n")
+			}
+			return
+		}
+
+		item = &fakeMetaItem{expires: fakeMetaExpires(ttl), cas: s.nextCAS(), winSent: true}
+		s.items[key] = item
+		ret = append(ret, "W")
+	} else if item.stale || item.winSent {
+		if !item.winSent {
+			item.winSent = true
+			ret = append(ret, "W")
+		} else {
+			ret = append(ret, "Z")
+		}
+
+		if item.stale {
+			ret = append(ret, "X")
+		}
+	}
+
+	if ttl, found := flags['T']; found {
+		item.expires = fakeMetaExpires(ttl)
+	}
+
+	if opaque, found := flags['O']; found {
+		ret = append(ret, "O"+opaque)
+	}
+
+	if _, found := flags['k']; found {
+		ret = append(ret, "k"+key)
+	}
+
+	if _, found := flags['c']; found {
+		ret = append(ret, "c"+strconv.FormatUint(item.cas, 10))
+	}
+
+	if _, found := flags['t']; found {
+		remaining := -1
+		if !item.expires.IsZero() {
+			remaining = int(time.Until(item.expires).Seconds() + 0.5)
+		}
+		ret = append(ret, "t"+strconv.Itoa(remaining))
+	}
+
+	if _, found := flags['v']; found {
+		w.WriteString(strings.TrimSpace(fmt.Sprintf("VA %d %s", len(item.value), strings.Join(ret, " "))) + "\r\n")
+		w.Write(item.value)
+		w.WriteString("\r\n")
+		return
+	}
+
+	w.WriteString(strings.TrimSpace("HD "+strings.Join(ret, " ")) + "\r\n")
+}
+
+func (s *fakeMetaServer) metaArithmetic(w *bufio.Writer, key string, item *fakeMetaItem, flags map[byte]string) {
+	if item == nil {
+		ttl, found := flags['N']
+		if !found {
+			w.WriteString("NF\r\n")
+			return
+		}
+
+		initial := flags['J']
+		if initial == "" {
+			initial = "0"
+		}
+
+		item = &fakeMetaItem{value: []byte(initial), expires: fakeMetaExpires(ttl), cas: s.nextCAS()}
+		s.items[key] = item
+	} else {
+		current, err := strconv.ParseUint(string(item.value), 10, 64)
+		if err != nil {
+			w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return
+		}
+
+		delta := uint64(1)
+		if d, found := flags['D']; found {
+			delta, _ = strconv.ParseUint(d, 10, 64)
+		}
+
+		switch flags['M'] {
+		case "D", "d", "-":
+			if delta > current {
+				current = 0
+			} else {
+				current -= delta
+			}
+		default:
+			current += delta
+		}
+
+		item.value = []byte(strconv.FormatUint(current, 10))
+		item.cas = s.nextCAS()
+	}
+
+	if _, found := flags['v']; found {
+		w.WriteString(fmt.Sprintf("VA %d\r\n%s\r\n", len(item.value), item.value))
+		return
+	}
+
+	w.WriteString("HD\r\n")
+}
+
+func (s *fakeMetaServer) nextCAS() uint64 {
+	s.cas++
+	return s.cas
+}
+
+func fakeMetaExpires(ttl string) time.Time {
+	seconds, _ := strconv.ParseInt(ttl, 10, 64)
+	if seconds <= 0 {
+		return time.Time{}
+	}
+
+	if seconds > 30*24*60*60 {
+		return time.Unix(seconds, 0)
+	}
+
+	return time.Now().Add(time.Duration(seconds) * time.Second)
+}
+
+func TestMetaMemCacheSetSuccessWithString(t *testing.T) {
+	key := "cache_key"
+	val := "value"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"value"`), value)
+
+	err = cache.Add(key, val)
+	assert.Equal(t, ErrCacheAlreadyExists, err)
+
+	_, err = cache.Pull(key)
+	assert.NoError(t, err)
+	assert.False(t, cache.Has(key))
+
+	_, err = cache.Get(key)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestMetaMemCacheSetSuccessWithLargeValue(t *testing.T) {
+	key := "large_key"
+	val := strings.Repeat("report", 500000)
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, `"`+val+`"`, string(value))
+
+	cache.Delete(key)
+	server.mu.Lock()
+	assert.Empty(t, server.items)
+	server.mu.Unlock()
+}
+
+func TestMetaMemCacheMultiSuccess(t *testing.T) {
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestMetaMemCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), value)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestMetaMemCacheCompareAndSwap(t *testing.T) {
+	key := "cache_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "other value")
+	assert.Equal(t, ErrCASConflict, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestMetaMemCacheGetMultiSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(3 * time.Second, []string{server.addr()}, WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.Error(t, err)
+}
+
+func TestMetaMemCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, ttl)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestMetaMemCacheGetWithLease(t *testing.T) {
+	key := "cache_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	leases, ok := cache.(LeaseCache)
+	assert.True(t, ok)
+
+	lease, err := leases.GetWithLease(key, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, lease.Win)
+	assert.Nil(t, lease.Value)
+
+	lease, err = leases.GetWithLease(key, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, lease.Win)
+	assert.Nil(t, lease.Value)
+
+	_, err = cache.Get(key)
+	assert.Equal(t, ErrCacheExpired, err)
+
+	_, err = leases.GetWithLease(key, 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	lease, err = leases.GetWithLease(key, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, lease.Win)
+	assert.False(t, lease.Stale)
+	assert.Equal(t, []byte(`"value"`), lease.Value)
+
+	err = leases.Invalidate(key)
+	assert.NoError(t, err)
+
+	_, err = cache.Get(key)
+	assert.Equal(t, ErrCacheExpired, err)
+	assert.False(t, cache.Has(key))
+	_, err = cache.TTL(key)
+	assert.NoError(t, err)
+	values, err := cache.GetMulti([]string{key})
+	assert.Empty(t, values)
+	assert.Equal(t, MultiError{key: ErrCacheNotFound}, err)
+
+	lease, err = leases.GetWithLease(key, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, lease.Win)
+	assert.True(t, lease.Stale)
+	assert.Equal(t, []byte(`"value"`), lease.Value)
+
+	lease, err = leases.GetWithLease(key, time.Second)
+	assert.NoError(t, err)
+	assert.False(t, lease.Win)
+	assert.True(t, lease.Stale)
+	assert.Equal(t, []byte(`"value"`), lease.Value)
+
+	err = leases.Invalidate("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestMetaMemCacheGetWithLeaseErrorNotSupported(t *testing.T) {
+	cache, err := NewDefaultCache(5 * time.Second)
+	assert.NoError(t, err)
+
+	leases := cache.(LeaseCache)
+
+	_, err = leases.GetWithLease("cache_key", time.Second)
+	assert.Equal(t, ErrNotSupported, err)
+	assert.Equal(t, ErrNotSupported, leases.Invalidate("cache_key"))
+}
+
+func TestMetaMemCacheAddSuccessWithLargeValue(t *testing.T) {
+	key := "large_key"
+	val := strings.Repeat("report", 500000)
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, `"`+val+`"`, string(value))
+
+	server.mu.Lock()
+	items := len(server.items)
+	server.mu.Unlock()
+
+	err = cache.Add(key, val)
+	assert.Equal(t, ErrCacheAlreadyExists, err)
+
+	server.mu.Lock()
+	assert.Len(t, server.items, items)
+	server.mu.Unlock()
+}
+
+func TestMetaMemCacheStreamChunksFollowTheKey(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 200000)
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	c, err := NewMetaMemCache(5 * time.Second, []string{server.addr()})
+	assert.NoError(t, err)
+
+	chunks := func() []string {
+		manifest, err := c.(*cache).chunkedManifest(key)
+		assert.NoError(t, err)
+		assert.NotNil(t, manifest)
+
+		var keys []string
+		for i := 0; i < manifest.Chunks; i++ {
+			keys = append(keys, c.(*cache).key(chunkKey(key, *manifest, i)))
+		}
+
+		return keys
+	}
+
+	assertRemoved := func(keys []string) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		for _, k := range keys {
+			assert.NotContains(t, server.items, k)
+		}
+	}
+
+	err = c.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+	written := chunks()
+
+	err = c.Persist(key)
+	assert.NoError(t, err)
+
+	server.mu.Lock()
+	for _, k := range written {
+		assert.True(t, server.items[k].expires.IsZero())
+	}
+	server.mu.Unlock()
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+	assertRemoved(written)
+
+	err = c.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+	written = chunks()
+
+	values, err := c.GetMulti([]string{key})
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(values[key]))
+
+	err = c.DeleteMulti([]string{key})
+	assert.NoError(t, err)
+	assertRemoved(written)
+}
@@ -2,6 +2,9 @@ package cache
 
 import (
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -219,3 +222,311 @@ func TestMemCacheExpired(t *testing.T) {
 	_, err = cache.Pull(key)
 	assert.Error(t, err)
 }
+
+func TestMemCacheMultiSuccess(t *testing.T) {
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestMemCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestMemCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestMemCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestMemCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestMemCacheTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.TTL(key)
+	assert.Equal(t, ErrNotSupported, err)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	time.Sleep(6 * time.Second)
+	assert.True(t, cache.Has(key))
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestMemCacheGetMultiSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewMemCacheWithOptions(3 * time.Second, []string{"0.0.0.0:11211"}, WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.Error(t, err)
+}
+
+func TestMemCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewMemCacheWithOptions(3 * time.Second, []string{"0.0.0.0:11211"}, WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestMemCacheDeletePrefixErrorNotSupported(t *testing.T) {
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestMemCacheSetStreamAndGetStream(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 200000)
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+
+	stream, err := cache.GetStream(key)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, val, string(content))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(value))
+}
+
+func TestMemCacheSetSuccessWithLargeValue(t *testing.T) {
+	key := "large_key"
+	val := strings.Repeat("report", 500000)
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+
+	cacheValue := new(string)
+	err = json.Unmarshal(value, cacheValue)
+	assert.NoError(t, err)
+	assert.Equal(t, val, *cacheValue)
+
+	_, err = cache.Pull(key)
+	assert.NoError(t, err)
+	assert.False(t, cache.Has(key))
+}
+
+func TestMemCacheGetErrorMissingChunk(t *testing.T) {
+	key := "large_key"
+	c, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+
+	err = c.Set(key, strings.Repeat("report", 500000))
+	assert.NoError(t, err)
+
+	manifest, err := c.(*cache).chunkedManifest(key)
+	assert.NoError(t, err)
+	assert.NotNil(t, manifest)
+	assert.NoError(t, c.(*cache).memCacheClient.Delete(chunkKey(key, *manifest, 1)))
+
+	_, err = c.Get(key)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestMemCacheKeyIsHashedWhenIllegal(t *testing.T) {
+	c := &cache{cacheType: cacheTypeMemcache, prefix: "app:"}
+
+	assert.Equal(t, "app:cache_key", c.key("cache_key"))
+
+	for _, key := range []string{"cache key", "cache\nkey", strings.Repeat("k", 300)} {
+		stored := c.key(key)
+		assert.True(t, strings.HasPrefix(stored, "app:"+memcacheHashedKeyPrefix))
+		assert.True(t, legalMemcacheKey(stored))
+	}
+
+	assert.NotEqual(t, c.key("cache key"), c.key("cache  key"))
+}
+
+func TestMemCacheErrorInvalidPrefix(t *testing.T) {
+	_, err := NewMemCacheWithOptions(5 * time.Second, []string{"0.0.0.0:11211"}, WithPrefix("my app:"))
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+}
+
+func TestMemCacheExpirationConversion(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &cache{cacheType: cacheTypeMemcache, clock: func() time.Time { return now }}
+
+	assert.Equal(t, int32(0), c.memcacheExpiration(0))
+	assert.Equal(t, int32(1), c.memcacheExpiration(300 * time.Millisecond))
+	assert.Equal(t, int32(2), c.memcacheExpiration(1500 * time.Millisecond))
+	assert.Equal(t, int32(60), c.memcacheExpiration(time.Minute))
+	assert.Equal(t, int32(30*24*60*60), c.memcacheExpiration(30 * 24 * time.Hour))
+	assert.Equal(t, int32(now.Add(31 * 24 * time.Hour).Unix()), c.memcacheExpiration(31 * 24 * time.Hour))
+}
+
+func TestMemCacheAddSuccessWithLargeValue(t *testing.T) {
+	key := "large_key"
+	val := strings.Repeat("report", 500000)
+	cache, err := NewMemCache(5 * time.Second, "0.0.0.0:11211")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.Add(key, val)
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, `"`+val+`"`, string(value))
+
+	err = cache.Add(key, val)
+	assert.Equal(t, ErrCacheAlreadyExists, err)
+}
+
+func TestMemCacheHasUsesMetaProbe(t *testing.T) {
+	key := "cache_key"
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	cache, err := NewMemCache(5 * time.Second, server.addr())
+	assert.NoError(t, err)
+	assert.False(t, cache.Has(key))
+
+	server.mu.Lock()
+	server.items[key] = &fakeMetaItem{value: []byte(`"value"`)}
+	server.mu.Unlock()
+
+	assert.True(t, cache.Has(key))
+}
+
+func TestMemCacheCloseReleasesMetaConnections(t *testing.T) {
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	c, err := NewMemCache(5 * time.Second, server.addr())
+	assert.NoError(t, err)
+	assert.False(t, c.Has("cache_key"))
+	assert.NotEmpty(t, c.(*cache).metaClient.idle)
+
+	assert.NoError(t, c.Close())
+	assert.Empty(t, c.(*cache).metaClient.idle)
+}
@@ -2,6 +2,9 @@ package cache
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -219,3 +222,504 @@ func TestRedisCacheExpired(t *testing.T) {
 	_, err = cache.Pull(key)
 	assert.Error(t, err)
 }
+
+func TestRedisCacheMultiSuccess(t *testing.T) {
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"multi_key_1": "value_1",
+		"multi_key_2": 2,
+	})
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{"multi_key_1", "multi_key_2", "multi_key_3"})
+	assert.Error(t, err)
+	assert.Contains(t, err.(MultiError), "multi_key_3")
+	assert.Equal(t, []byte(`"value_1"`), values["multi_key_1"])
+	assert.Equal(t, []byte(`2`), values["multi_key_2"])
+
+	err = cache.DeleteMulti([]string{"multi_key_1", "multi_key_2"})
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("multi_key_1"))
+	assert.False(t, cache.Has("multi_key_2"))
+}
+
+func TestRedisCacheIncrementAndDecrement(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	value, err := cache.Increment(key, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+
+	value, err = cache.Increment(key, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), value)
+
+	value, err = cache.Decrement(key, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+
+	stored, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("4"), stored)
+}
+
+func TestRedisCacheIncrementErrorNotInteger(t *testing.T) {
+	key := "counter_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, err = cache.Increment(key, 1)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestRedisCacheCompareAndSwapSuccess(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestRedisCacheCompareAndSwapErrorConflict(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "changed value")
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrCASConflict, err)
+}
+
+func TestRedisCacheCompareAndSwapStreamedValue(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 200000)
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+
+	value, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(value))
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.NoError(t, err)
+
+	value, err = cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestRedisCacheCompareAndSwapErrorNotHash(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+
+	_, version, err := cache.GetWithVersion(key)
+	assert.NoError(t, err)
+
+	err = cache.CompareAndSwap(key, version, "new value")
+	assert.Equal(t, ErrNotHash, err)
+}
+
+func TestRedisCacheTTLTouchAndPersist(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 5*time.Second)
+
+	err = cache.Touch(key, time.Minute)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 5*time.Second && ttl <= time.Minute)
+
+	err = cache.Persist(key)
+	assert.NoError(t, err)
+
+	ttl, err = cache.TTL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	_, err = cache.TTL("missing_key")
+	assert.Equal(t, ErrCacheNotFound, err)
+
+	err = cache.Touch("missing_key", time.Minute)
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestRedisCacheGetMultiSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(3 * time.Second, "0.0.0.0:6379", "redis_password", WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.GetMulti([]string{key})
+	assert.Error(t, err)
+}
+
+func TestRedisCacheSlidingExpiration(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(3 * time.Second, "0.0.0.0:6379", "redis_password", WithSlidingExpiration())
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, err = cache.Get(key)
+	assert.NoError(t, err)
+
+	time.Sleep(4 * time.Second)
+	_, err = cache.Get(key)
+	assert.Error(t, err)
+}
+
+func TestRedisCacheDeletePrefix(t *testing.T) {
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.SetMulti(map[string]interface{}{
+		"user:1":   1,
+		"user:2":   2,
+		"session:1": 1,
+	})
+	assert.NoError(t, err)
+
+	err = cache.DeletePrefix("user:")
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("user:1"))
+	assert.False(t, cache.Has("user:2"))
+	assert.True(t, cache.Has("session:1"))
+}
+
+func TestRedisCacheSetStreamAndGetStream(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 200000)
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+
+	stream, err := cache.GetStream(key)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, val, string(content))
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(value))
+
+	cache.Delete(key)
+	assert.False(t, cache.Has(key))
+}
+
+func TestRedisCacheHashFields(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value", "count": 1})
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"count": 2})
+	assert.NoError(t, err)
+
+	value, err := cache.HGetField(key, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	fields, err := cache.HGetAll(key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte(`"value"`), "count": []byte("2")}, fields)
+
+	document, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "value", "count": 2}`, string(document))
+
+	ttl, err := cache.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 4*time.Second)
+
+	_, err = cache.HGetField(key, "missing")
+	assert.Equal(t, ErrCacheNotFound, err)
+}
+
+func TestRedisCacheHashFieldsGetMulti(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+
+	err = cache.Set("cache_key", "value")
+	assert.NoError(t, err)
+
+	values, err := cache.GetMulti([]string{key, "cache_key", "missing_key"})
+	assert.Equal(t, MultiError{"missing_key": ErrCacheNotFound}, err)
+	assert.JSONEq(t, `{"name": "value"}`, string(values[key]))
+	assert.Equal(t, []byte(`"value"`), values["cache_key"])
+}
+
+func TestRedisCacheHashFieldsErrorNotHash(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	err = cache.Set(key, "value")
+	assert.NoError(t, err)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.Equal(t, ErrNotHash, err)
+}
+
+func TestRedisCacheWriteBatching(t *testing.T) {
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password", WithWriteBatching(10, 50 * time.Millisecond))
+	assert.NoError(t, err)
+
+	writer := cache.(BatchWriter)
+
+	var futures []*Future
+	for i := 0; i < 25; i++ {
+		futures = append(futures, writer.SetAsync("batch_key_"+strconv.Itoa(i), i))
+	}
+
+	err = writer.Sync()
+	assert.NoError(t, err)
+
+	for _, future := range futures {
+		assert.NoError(t, future.Wait())
+	}
+
+	value, err := cache.Get("batch_key_7")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("7"), value)
+
+	err = writer.DeleteAsync("batch_key_7").Wait()
+	assert.NoError(t, err)
+	assert.False(t, cache.Has("batch_key_7"))
+}
+
+func TestRedisCacheWriteBatchingFlushesAfterDelay(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password", WithWriteBatching(100, 20 * time.Millisecond))
+	assert.NoError(t, err)
+
+	writer := cache.(BatchWriter)
+
+	future := writer.SetAsync(key, "value")
+
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("batch was not sent")
+	}
+
+	assert.NoError(t, future.Wait())
+
+	err = cache.Set(key, "new value")
+	assert.NoError(t, err)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"new value"`), value)
+}
+
+func TestRedisCacheHasHashKey(t *testing.T) {
+	key := "hash_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	err = cache.HSetFields(key, map[string]interface{}{"name": "value"})
+	assert.NoError(t, err)
+	assert.True(t, cache.Has(key))
+
+	err = cache.Add(key, "value")
+	assert.Equal(t, ErrCacheAlreadyExists, err)
+}
+
+func TestRedisCacheAddSeesPendingBatchedWrites(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password", WithWriteBatching(100, time.Second))
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	writer := cache.(BatchWriter)
+
+	future := writer.SetAsync(key, "value")
+
+	err = cache.Add(key, "other value")
+	assert.Equal(t, ErrCacheAlreadyExists, err)
+	assert.NoError(t, future.Wait())
+}
+
+func TestRedisCacheReadsSeePendingBatchedWrites(t *testing.T) {
+	key := "cache_key"
+	cache, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password", WithWriteBatching(100, time.Second))
+	assert.NoError(t, err)
+	cache.Delete(key)
+
+	writer := cache.(BatchWriter)
+
+	future := writer.SetAsync(key, 1)
+
+	value, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.NoError(t, future.Wait())
+
+	future = writer.SetAsync(key, 5)
+
+	counter, err := cache.Increment(key, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), counter)
+	assert.NoError(t, future.Wait())
+
+	future = writer.SetAsync(key, 7)
+
+	mapped, err := cache.GetMapped(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("7"), mapped.Bytes())
+	assert.NoError(t, mapped.Release())
+	assert.NoError(t, future.Wait())
+
+	future = writer.DeleteAsync(key)
+	assert.False(t, cache.Has(key))
+	assert.NoError(t, future.Wait())
+}
+
+func TestRedisCacheDeleteAsyncRemovesChunks(t *testing.T) {
+	key := "stream_key"
+	c, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password", WithWriteBatching(100, time.Second))
+	assert.NoError(t, err)
+
+	writer := c.(BatchWriter)
+
+	err = c.SetStream(key, strings.NewReader(strings.Repeat("export;", 200000)))
+	assert.NoError(t, err)
+
+	manifest, err := c.(*cache).chunkedManifest(key)
+	assert.NoError(t, err)
+	assert.NotNil(t, manifest)
+
+	err = writer.DeleteAsync(key).Wait()
+	assert.NoError(t, err)
+	assert.False(t, c.Has(key))
+
+	for i := 0; i < manifest.Chunks; i++ {
+		assert.False(t, c.Has(chunkKey(key, *manifest, i)))
+	}
+}
+
+func TestRedisCacheStreamChunksFollowTheKey(t *testing.T) {
+	key := "stream_key"
+	val := strings.Repeat("export;", 200000)
+	c, err := NewRedisCache(5 * time.Second, "0.0.0.0:6379", "redis_password")
+	assert.NoError(t, err)
+
+	chunks := func() []string {
+		manifest, err := c.(*cache).chunkedManifest(key)
+		assert.NoError(t, err)
+		assert.NotNil(t, manifest)
+
+		var keys []string
+		for i := 0; i < manifest.Chunks; i++ {
+			keys = append(keys, chunkKey(key, *manifest, i))
+		}
+
+		return keys
+	}
+
+	err = c.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+	written := chunks()
+
+	err = c.Persist(key)
+	assert.NoError(t, err)
+	for _, k := range written {
+		ttl, err := c.TTL(k)
+		assert.NoError(t, err)
+		assert.Equal(t, NoExpiration, ttl)
+	}
+
+	err = c.Set(key, "value")
+	assert.NoError(t, err)
+	for _, k := range written {
+		assert.False(t, c.Has(k))
+	}
+
+	err = c.SetStream(key, strings.NewReader(val))
+	assert.NoError(t, err)
+	written = chunks()
+
+	values, err := c.GetMulti([]string{key})
+	assert.NoError(t, err)
+	assert.Equal(t, val, string(values[key]))
+
+	err = c.DeleteMulti([]string{key})
+	assert.NoError(t, err)
+	for _, k := range written {
+		assert.False(t, c.Has(k))
+	}
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v7"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Version identifies the state of a cache item when it was read with GetWithVersion
+type Version struct {
+	token        string
+	memCacheItem *memcache.Item
+	manifest     *streamManifest
+}
+
+// Sets the value only if the sha1 of the current value matches the version. Returns 1 if the value was set,
+// 0 on a conflict and -1 if the key doesn't exist
+var redisCompareAndSwap = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return -1
+end
+if redis.sha1hex(current) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// This returns the value in the cache for the given key together with its version. The version can be passed to
+// CompareAndSwap to update the value only if nobody else changed it in the meantime
+func (c *cache) GetWithVersion(key string) ([]byte, Version, error) {
+	if err := validateKey(key); err != nil {
+		return nil, Version{}, err
+	}
+
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		value, err := c.getDefaultCache(key, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		return value, Version{token: strconv.FormatUint(c.items[key].version, 10)}, nil
+	case cacheTypeFile:
+		header, value, err := c.getFileCacheWithHeader(key, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		return value, Version{token: strconv.FormatUint(header.Version, 10)}, nil
+	case cacheTypeRedis:
+		// The version is the digest of the stored value, which is the manifest of a streamed value, since that is what
+		// redisCompareAndSwap compares
+		stored, err := c.getRedisCache(key, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		value, err := c.readChunked(key, stored, nil, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		version := Version{token: digest(stored)}
+		if manifest, ok := parseStreamManifest(stored); ok {
+			version.manifest = &manifest
+		}
+
+		return value, version, nil
+	case cacheTypeLog:
+		value, entry, err := c.getLogCacheWithEntry(key, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		return value, Version{token: strconv.FormatUint(entry.version, 10)}, nil
+	case cacheTypeBolt:
+		value, version, err := c.getBoltCacheWithVersion(key, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		return value, Version{token: strconv.FormatUint(version, 10)}, nil
+	case cacheTypeMemcache:
+		item, err := c.memCacheClient.Get(c.key(key))
+		if err != nil {
+			return nil, Version{}, ErrCacheNotFound
+		}
+
+		value, err := c.readChunked(key, item.Value, nil, false)
+		if err != nil {
+			return nil, Version{}, err
+		}
+
+		return value, Version{memCacheItem: item}, nil
+	case cacheTypeMetaMemcache:
+		return c.getWithVersionMetaCache(key)
+	}
+
+	return nil, Version{}, ErrCacheNotFound
+}
+
+// This sets the value to the key only if the cache was not modified since the version was read with GetWithVersion.
+// Returns ErrCASConflict if it was modified and ErrCacheNotFound if it doesn't exist anymore
+func (c *cache) CompareAndSwap(key string, version Version, value interface{}) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	val, err := json.MarshalIndent(value, "", " ")
+	if err != nil {
+		return err
+	}
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		if _, err := c.getDefaultCache(key, false); err != nil {
+			return ErrCacheNotFound
+		}
+
+		if strconv.FormatUint(c.items[key].version, 10) != version.token {
+			return ErrCASConflict
+		}
+	case cacheTypeFile:
+		header, _, err := c.readValidCacheFile(key)
+		if err != nil {
+			return ErrCacheNotFound
+		}
+
+		if strconv.FormatUint(header.Version, 10) != version.token {
+			return ErrCASConflict
+		}
+	case cacheTypeLog:
+		entry, found := c.logStore.lookup(key)
+		if !found {
+			return ErrCacheNotFound
+		}
+
+		if strconv.FormatUint(entry.version, 10) != version.token {
+			return ErrCASConflict
+		}
+	case cacheTypeBolt:
+		return c.boltDB.Update(func(tx *bolt.Tx) error {
+			_, _, current, err := c.boltGetWithVersion(tx, key)
+			if err != nil {
+				return ErrCacheNotFound
+			}
+
+			if strconv.FormatUint(current, 10) != version.token {
+				return ErrCASConflict
+			}
+
+			return c.boltPut(tx, key, val, c.expiresAt())
+		})
+	case cacheTypeRedis:
+		result, err := redisCompareAndSwap.Run(c.redisClient, []string{c.key(key)},
+			version.token, val, c.expiration.Milliseconds()).Int()
+		if err != nil {
+			// A hash that was stored with HSetFields can't be swapped
+			if strings.Contains(err.Error(), "WRONGTYPE") {
+				return ErrNotHash
+			}
+
+			return err
+		}
+
+		switch result {
+		case -1:
+			return ErrCacheNotFound
+		case 0:
+			return ErrCASConflict
+		}
+
+		if version.manifest != nil {
+			c.deleteChunks(key, *version.manifest)
+		}
+
+		return nil
+	case cacheTypeMemcache:
+		if version.memCacheItem == nil {
+			return ErrCASConflict
+		}
+
+		return c.compareAndSwapMemCache(key, *version.memCacheItem, val)
+	case cacheTypeMetaMemcache:
+		return c.compareAndSwapMetaCache(key, version, val)
+	}
+
+	return c.setBytes(key, val)
+}
+
+// Swaps the value of the memcache item if its CAS token is unchanged. Large values are written in chunks first and
+// only their manifest is swapped. The chunks of whichever value lost are removed
+func (c *cache) compareAndSwapMemCache(key string, item memcache.Item, val []byte) error {
+	previous := item.Value
+	item.Expiration = c.memcacheExpiration(c.expiration)
+
+	stored, written, err := c.chunkLargeValue(key, val)
+	if err != nil {
+		return err
+	}
+
+	item.Value = stored
+	err = c.memCacheClient.CompareAndSwap(&item)
+	if err != nil && written != nil {
+		c.deleteChunks(key, *written)
+	}
+
+	if manifest, ok := parseStreamManifest(previous); ok && err == nil {
+		c.deleteChunks(key, manifest)
+	}
+
+	switch err {
+	case nil:
+		return nil
+	case memcache.ErrCASConflict:
+		return ErrCASConflict
+	case memcache.ErrCacheMiss, memcache.ErrNotStored:
+		return ErrCacheNotFound
+	default:
+		return err
+	}
+}
+
+// Returns the hex encoded sha1 of the value, which is used as version by redis
+func digest(value []byte) string {
+	sum := sha1.Sum(value)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v7"
+)
+
+// This atomically adds delta to the integer stored in the key and returns the new value. A missing key is created
+// with the value delta and the expiration of the cache. Returns ErrNotInteger if the existing value is not an integer.
+// Memcache counters are unsigned, so they never go below 0
+func (c *cache) Increment(key string, delta int64) (int64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return c.incr(key, delta)
+}
+
+// This atomically subtracts delta from the integer stored in the key and returns the new value. See Increment
+func (c *cache) Decrement(key string, delta int64) (int64, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return c.incr(key, -delta)
+}
+
+func (c *cache) incr(key string, delta int64) (int64, error) {
+	switch c.cacheType {
+	case cacheTypeDefault:
+		return c.incrDefaultCache(key, delta)
+	case cacheTypeFile:
+		return c.incrFileCache(key, delta)
+	case cacheTypeRedis:
+		return c.incrRedisCache(key, delta)
+	case cacheTypeMemcache:
+		return c.incrMemCache(key, delta)
+	case cacheTypeMetaMemcache:
+		return c.incrMetaCache(key, delta)
+	case cacheTypeLog:
+		return c.incrLogCache(key, delta)
+	case cacheTypeBolt:
+		return c.incrBoltCache(key, delta)
+	}
+
+	return 0, ErrCacheNotFound
+}
+
+// Updates the counter in memory. The expiration of an existing item is kept
+func (c *cache) incrDefaultCache(key string, delta int64) (int64, error) {
+	value, err := c.getDefaultCache(key, false)
+	if err != nil {
+		return delta, c.setBytes(key, []byte(strconv.FormatInt(delta, 10)))
+	}
+
+	current, err := parseCounter(value)
+	if err != nil {
+		return 0, err
+	}
+
+	c.version++
+	item := c.items[key]
+	item.value = []byte(strconv.FormatInt(current+delta, 10))
+	item.version = c.version
+	c.items[key] = item
+
+	return current + delta, nil
+}
+
+// Updates the counter in the file. The expiration of an existing file is kept
+func (c *cache) incrFileCache(key string, delta int64) (int64, error) {
+	header, value, err := c.readValidCacheFile(key)
+	if err != nil {
+		if err := c.writeCacheFile(key, newFileHeader(key, c.expiresAt()), []byte(strconv.FormatInt(delta, 10))); err != nil {
+			return 0, err
+		}
+
+		c.cacheFiles[key] = struct{}{}
+
+		return delta, nil
+	}
+
+	current, err := parseCounter(value)
+	if err != nil {
+		return 0, err
+	}
+
+	// The value changes, so it gets a new version while keeping the expiration
+	header.Version = 0
+	if err := c.writeCacheFile(key, header, []byte(strconv.FormatInt(current+delta, 10))); err != nil {
+		return 0, err
+	}
+
+	return current + delta, nil
+}
+
+// Creates the key with the expiration of the cache if it doesn't exist and increments it in a single transaction
+func (c *cache) incrRedisCache(key string, delta int64) (int64, error) {
+	var incr *redis.IntCmd
+
+	if _, err := c.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SetNX(c.key(key), 0, c.expiration)
+		incr = pipe.IncrBy(c.key(key), delta)
+
+		return nil
+	}); err != nil {
+		if strings.Contains(err.Error(), "not an integer") {
+			return 0, ErrNotInteger
+		}
+
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// Increments or decrements the memcache counter. If it doesn't exist it is added with the expiration of the cache
+func (c *cache) incrMemCache(key string, delta int64) (int64, error) {
+	for {
+		var value uint64
+		var err error
+
+		if delta < 0 {
+			value, err = c.memCacheClient.Decrement(c.key(key), uint64(-delta))
+		} else {
+			value, err = c.memCacheClient.Increment(c.key(key), uint64(delta))
+		}
+
+		if err == nil {
+			return int64(value), nil
+		}
+
+		if err != memcache.ErrCacheMiss {
+			if strings.Contains(err.Error(), "non-numeric") {
+				return 0, ErrNotInteger
+			}
+
+			return 0, err
+		}
+
+		initial := delta
+		if initial < 0 {
+			initial = 0
+		}
+
+		err = c.memCacheClient.Add(&memcache.Item{
+			Key:        c.key(key),
+			Value:      []byte(strconv.FormatInt(initial, 10)),
+			Expiration: c.memcacheExpiration(c.expiration),
+		})
+		if err == nil {
+			return initial, nil
+		}
+
+		// Another client created the counter in the meantime, so increment it instead
+		if err != memcache.ErrNotStored {
+			return 0, err
+		}
+	}
+}
+
+func parseCounter(value []byte) (int64, error) {
+	current, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+
+	return current, nil
+}
@@ -0,0 +1,487 @@
+package cache
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opener creates a cache from a parsed DSN. Custom backends register an Opener for their scheme with Register
+type Opener func(dsn *url.URL) (Cache, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]Opener)
+)
+
+func init() {
+	Register(cacheTypeDefault, openDefaultCache)
+	Register(cacheTypeFile, openFileCache)
+	Register(cacheTypeRedis, openRedisCache)
+	Register(cacheTypeMemcache, openMemCache)
+	Register(cacheTypeLog, openLogCache)
+	Register(cacheTypeBolt, openBoltCache)
+	Register(cacheTypeMetaMemcache, openMetaMemCache)
+}
+
+// Register makes a cache backend available to Open under the given scheme.
+// It panics if opener is nil or the scheme is already registered
+func Register(scheme string, opener Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if opener == nil {
+		panic("cache lib: Register opener is nil")
+	}
+
+	if _, found := openers[scheme]; found {
+		panic("cache lib: Register called twice for scheme " + scheme)
+	}
+
+	openers[scheme] = opener
+}
+
+// Schemes returns a sorted list of the registered DSN schemes
+func Schemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Open creates a cache from a DSN. The scheme selects the backend, e.g.
+//
+//	memory://?ttl=5m&max_entries=10000
+//	file:///var/cache/app?ttl=1h&sliding=true&durability=dir&file_mode=0600
+//	redis://:password@host:6379/2?ttl=30s&prefix=app&batch_size=100&batch_delay=5ms
+//	memcache://host1:11211,host2:11211?ttl=1m&hashing=ketama&health_check=5s
+//	memcache-meta://host1:11211,host2:11211?ttl=1m
+//	log:///var/cache/app?ttl=1h&segment_size=67108864
+//	bolt:///var/cache/app.db?ttl=1h&namespace=sessions
+func Open(dsn string) (Cache, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDSN, err)
+	}
+
+	openersMu.RLock()
+	opener, found := openers[u.Scheme]
+	openersMu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("%w: unknown scheme %q", ErrInvalidDSN, u.Scheme)
+	}
+
+	return opener(u)
+}
+
+func openDefaultCache(u *url.URL) (Cache, error) {
+	query, err := parseDSNQuery(u, "ttl", "max_entries", "sliding")
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return nil, err
+	}
+
+	maxEntries, err := dsnInt(query, "max_entries")
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDefaultCache(ttl, append(opts, WithMaxEntries(maxEntries))...)
+}
+
+func openFileCache(u *url.URL) (Cache, error) {
+	query, err := parseDSNQuery(u, "ttl", "sliding", "durability", "max_bytes", "max_files", "eviction", "quarantine",
+		"file_mode", "dir_mode", "create_dir", "mmap_threshold")
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return nil, err
+	}
+
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("%w: file path is missing", ErrInvalidDSN)
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	permOpts, err := dsnPermissions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, permOpts...)
+
+	durability, err := dsnDurability(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, WithDurability(durability))
+
+	maxBytes, err := dsnInt(query, "max_bytes")
+	if err != nil {
+		return nil, err
+	}
+
+	maxFiles, err := dsnInt(query, "max_files")
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, WithMaxBytes(int64(maxBytes)), WithMaxFiles(maxFiles))
+
+	if value := query.Get("mmap_threshold"); value != "" {
+		threshold, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("%w: invalid mmap_threshold %q", ErrInvalidDSN, value)
+		}
+
+		opts = append(opts, WithMmapThreshold(threshold))
+	}
+
+	if value := query.Get("quarantine"); value != "" {
+		quarantine, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid quarantine %q", ErrInvalidDSN, value)
+		}
+
+		if quarantine {
+			opts = append(opts, WithQuarantine())
+		}
+	}
+
+	switch eviction := query.Get("eviction"); eviction {
+	case "", "lru":
+	case "oldest":
+		opts = append(opts, WithEvictionPolicy(EvictOldest))
+	default:
+		return nil, fmt.Errorf("%w: invalid eviction %q", ErrInvalidDSN, eviction)
+	}
+
+	return NewFileCache(ttl, path, opts...)
+}
+
+func openLogCache(u *url.URL) (Cache, error) {
+	query, err := parseDSNQuery(u, "ttl", "sliding", "durability", "segment_size", "file_mode", "dir_mode", "create_dir")
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return nil, err
+	}
+
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("%w: log path is missing", ErrInvalidDSN)
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	permOpts, err := dsnPermissions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, permOpts...)
+
+	durability, err := dsnDurability(query)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentSize, err := dsnInt(query, "segment_size")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLogCache(ttl, path, append(opts, WithDurability(durability), WithSegmentSize(int64(segmentSize)))...)
+}
+
+func openBoltCache(u *url.URL) (Cache, error) {
+	query, err := parseDSNQuery(u, "ttl", "sliding", "namespace", "file_mode", "dir_mode", "create_dir")
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return nil, err
+	}
+
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, fmt.Errorf("%w: bolt path is missing", ErrInvalidDSN)
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	permOpts, err := dsnPermissions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, permOpts...)
+
+	return NewBoltCache(ttl, path, append(opts, WithNamespace(query.Get("namespace")))...)
+}
+
+func openRedisCache(u *url.URL) (Cache, error) {
+	query, err := parseDSNQuery(u, "ttl", "prefix", "sliding", "batch_size", "batch_delay")
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return nil, err
+	}
+
+	var database int
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if database, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("%w: invalid redis database %q", ErrInvalidDSN, db)
+		}
+	}
+
+	var password string
+	if u.User != nil {
+		password, _ = u.User.Password()
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize, err := dsnInt(query, "batch_size")
+	if err != nil {
+		return nil, err
+	}
+
+	batchDelay, err := dsnDuration(query, "batch_delay")
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, WithDatabase(database), WithWriteBatching(batchSize, batchDelay))
+
+	return NewRedisCache(ttl, u.Host, password, opts...)
+}
+
+func openMemCache(u *url.URL) (Cache, error) {
+	ttl, servers, opts, err := parseMemCacheDSN(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMemCacheWithOptions(ttl, servers, opts...)
+}
+
+func openMetaMemCache(u *url.URL) (Cache, error) {
+	ttl, servers, opts, err := parseMemCacheDSN(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMetaMemCache(ttl, servers, opts...)
+}
+
+// Returns the ttl, the comma separated servers and the options of a memcache DSN
+func parseMemCacheDSN(u *url.URL) (time.Duration, []string, []Option, error) {
+	query, err := parseDSNQuery(u, "ttl", "prefix", "sliding", "hashing", "health_check", "eject_after")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	ttl, err := dsnDuration(query, "ttl")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if u.Host == "" {
+		return 0, nil, nil, fmt.Errorf("%w: memcache server is missing", ErrInvalidDSN)
+	}
+
+	opts, err := dsnOptions(query)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	switch hashing := query.Get("hashing"); hashing {
+	case "", "modulo":
+	case "ketama":
+		opts = append(opts, WithConsistentHashing(nil))
+	default:
+		return 0, nil, nil, fmt.Errorf("%w: invalid hashing %q", ErrInvalidDSN, hashing)
+	}
+
+	healthCheck, err := dsnDuration(query, "health_check")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	ejectAfter, err := dsnInt(query, "eject_after")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	opts = append(opts, WithHealthCheck(healthCheck, ejectAfter))
+
+	return ttl, strings.Split(u.Host, ","), opts, nil
+}
+
+// Returns the query of the DSN. Returns error if it contains a parameter that is not in allowed
+func parseDSNQuery(u *url.URL, allowed ...string) (url.Values, error) {
+	query := u.Query()
+
+	for name := range query {
+		known := false
+		for _, a := range allowed {
+			if name == a {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidDSN, name)
+		}
+	}
+
+	return query, nil
+}
+
+// Returns the options shared by all the cache types
+func dsnOptions(query url.Values) ([]Option, error) {
+	var opts []Option
+
+	if prefix := query.Get("prefix"); prefix != "" {
+		opts = append(opts, WithPrefix(prefix))
+	}
+
+	if value := query.Get("sliding"); value != "" {
+		sliding, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid sliding %q", ErrInvalidDSN, value)
+		}
+
+		if sliding {
+			opts = append(opts, WithSlidingExpiration())
+		}
+	}
+
+	return opts, nil
+}
+
+// Returns the permission options of the file based cache types
+func dsnPermissions(query url.Values) ([]Option, error) {
+	var opts []Option
+
+	for _, param := range []struct {
+		name   string
+		option func(os.FileMode) Option
+	}{
+		{"file_mode", WithFileMode},
+		{"dir_mode", WithDirMode},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || os.FileMode(mode) != os.FileMode(mode).Perm() {
+			return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidDSN, param.name, value)
+		}
+
+		opts = append(opts, param.option(os.FileMode(mode)))
+	}
+
+	if value := query.Get("create_dir"); value != "" {
+		create, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid create_dir %q", ErrInvalidDSN, value)
+		}
+
+		opts = append(opts, WithCreateDir(create))
+	}
+
+	return opts, nil
+}
+
+func dsnDurability(query url.Values) (Durability, error) {
+	switch durability := query.Get("durability"); durability {
+	case "", "file":
+		return DurabilityFile, nil
+	case "none":
+		return DurabilityNone, nil
+	case "dir":
+		return DurabilityDirectory, nil
+	default:
+		return 0, fmt.Errorf("%w: invalid durability %q", ErrInvalidDSN, durability)
+	}
+}
+
+func dsnDuration(query url.Values, name string) (time.Duration, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultExpiration, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidDSN, name, value)
+	}
+
+	return d, nil
+}
+
+func dsnInt(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidDSN, name, value)
+	}
+
+	return i, nil
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenDefaultCache(t *testing.T) {
+	c, err := Open("memory://?ttl=5m&max_entries=2&sliding=true")
+	assert.NoError(t, err)
+
+	cache := c.(*cache)
+	assert.Equal(t, cacheTypeDefault, cache.cacheType)
+	assert.Equal(t, 5*time.Minute, cache.expiration)
+	assert.Equal(t, 2, cache.maxEntries)
+	assert.True(t, cache.sliding)
+}
+
+func TestOpenFileCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open("file://" + dir + "?ttl=1h")
+	assert.NoError(t, err)
+
+	cache := c.(*cache)
+	assert.Equal(t, cacheTypeFile, cache.cacheType)
+	assert.Equal(t, time.Hour, cache.expiration)
+	assert.Equal(t, dir, cache.filePath)
+}
+
+func TestOpenErrorUnknownScheme(t *testing.T) {
+	_, err := Open("unknown://host")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+}
+
+func TestOpenErrorUnknownParameter(t *testing.T) {
+	_, err := Open("memory://?expiry=5m")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+}
+
+func TestOpenErrorInvalidTTL(t *testing.T) {
+	_, err := Open("memory://?ttl=five")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+
+	_, err = Open("memory://?ttl=-5m")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+}
+
+func TestOpenRegisteredScheme(t *testing.T) {
+	Register("custom", func(dsn *url.URL) (Cache, error) {
+		return NewDefaultCache(time.Minute)
+	})
+
+	cache, err := Open("custom://")
+	assert.NoError(t, err)
+	assert.NotNil(t, cache)
+	assert.Contains(t, Schemes(), "custom")
+	assert.Panics(t, func() {
+		Register("custom", openDefaultCache)
+	})
+}
+
+func TestOpenFileCacheDurability(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open("file://" + dir + "?durability=dir")
+	assert.NoError(t, err)
+	assert.Equal(t, DurabilityDirectory, c.(*cache).durability)
+
+	_, err = Open("file://" + dir + "?durability=always")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+}
+
+func TestOpenFileCachePermissions(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open("file://" + dir + "?file_mode=0600&dir_mode=0700")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), c.(*cache).perm.fileMode)
+	assert.Equal(t, os.FileMode(0700), c.(*cache).perm.dirMode)
+
+	_, err = Open("file://" + dir + "?file_mode=rw")
+	assert.True(t, errors.Is(err, ErrInvalidDSN))
+
+	_, err = Open("file://" + filepath.Join(dir, "missing") + "?create_dir=false")
+	assert.Error(t, err)
+}
+
+func TestOpenMemCacheConsistentHashing(t *testing.T) {
+	server := newFakeMetaServer(t)
+	defer server.close()
+
+	c, err := Open("memcache-meta://" + server.addr() + "?hashing=ketama&health_check=1s&eject_after=3")
+	assert.NoError(t, err)
+
+	ring, ok := c.(*cache).metaClient.selector.(*ketamaRing)
+	assert.True(t, ok)
+	assert.Equal(t, 3, ring.ejectAfter)
+
+	_, err = Open("memcache://localhost:11211?hashing=crc")
+	assert.Error(t, err)
+}
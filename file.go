@@ -0,0 +1,504 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Version of the layout of the cache files. Files with another version are ignored
+	fileFormatVersion = 1
+	// Prefix of the temporary files that are renamed to cache files once they are completely written
+	tempFilePrefix = ".tmp-"
+	// Name of the file in each first level subdirectory that is locked while an entry of that shard is modified
+	lockFileName = ".lock"
+	// Name of the subdirectory that corrupted cache files are moved to
+	quarantineDirName = "quarantine"
+	// Temporary files older than this are left over from a crash and are removed when the index is rebuilt
+	staleTempFileAge = time.Hour
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// fileHeader is stored as the first line of every cache file, followed by the value
+type fileHeader struct {
+	Format   int    `json:"format"`
+	Key      string `json:"key"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+	Flags    uint32 `json:"flags"`
+	Checksum uint32 `json:"checksum"`
+	Version  uint64 `json:"version"`
+}
+
+func newFileHeader(key string, expiration int64) fileHeader {
+	return fileHeader{
+		Format:  fileFormatVersion,
+		Key:     key,
+		Created: time.Now().UnixNano(),
+		Expires: expiration,
+	}
+}
+
+// Decodes the header line of a cache file. Returns ErrCacheCorrupted if it cannot be decoded and ErrCacheNotFound
+// if it is not a header for the key
+func parseFileHeader(line []byte, key string) (fileHeader, error) {
+	var header fileHeader
+
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, ErrCacheCorrupted
+	}
+
+	if header.Format != fileFormatVersion || header.Key != key {
+		return header, ErrCacheNotFound
+	}
+
+	return header, nil
+}
+
+func (h fileHeader) expired() bool {
+	return h.Expires > 0 && time.Now().UnixNano() > h.Expires
+}
+
+// fileExpiry remembers the expiration from the header of a cache file, which is valid as long as the file is the same.
+// Every write renames a new file into place, which changes its inode and usually its modification time
+type fileExpiry struct {
+	info    os.FileInfo
+	expires int64
+}
+
+// Reports whether a valid cache file exists for the key. The header is only read the first time a version of the
+// file is seen, after that a stat is enough
+func (c *cache) hasFileCache(key string) bool {
+	info, err := os.Stat(c.cacheFilePath(key))
+	if err != nil {
+		c.forgetExpiry(key)
+		return false
+	}
+
+	c.accessMu.Lock()
+	known, found := c.fileExpiry[key]
+	c.accessMu.Unlock()
+
+	if !found || !known.sameFile(info) {
+		header, err := c.readCacheFileHeader(key)
+		if err != nil {
+			return false
+		}
+
+		known = fileExpiry{info: info, expires: header.Expires}
+		c.rememberExpiry(key, known)
+	}
+
+	if (fileHeader{Expires: known.expires}).expired() {
+		_ = c.removeCacheFile(key)
+		return false
+	}
+
+	return true
+}
+
+func (e fileExpiry) sameFile(info os.FileInfo) bool {
+	return os.SameFile(e.info, info) && e.info.ModTime().Equal(info.ModTime()) && e.info.Size() == info.Size()
+}
+
+func (c *cache) rememberExpiry(key string, expiry fileExpiry) {
+	c.accessMu.Lock()
+	defer c.accessMu.Unlock()
+
+	c.fileExpiry[key] = expiry
+}
+
+func (c *cache) forgetExpiry(key string) {
+	c.accessMu.Lock()
+	defer c.accessMu.Unlock()
+
+	delete(c.fileExpiry, key)
+}
+
+// Returns the path of the cache file for the given key. The key is hashed so that it is always a safe file name,
+// and the files are spread over two levels of subdirectories named after the hash, e.g. ab/cd/abcd...
+func (c *cache) cacheFilePath(key string) string {
+	hash := digest([]byte(key))
+
+	return filepath.Join(c.filePath, hash[0:2], hash[2:4], hash)
+}
+
+// Locks the shard of the key against other goroutines and processes that share the cache directory, and returns the
+// function that releases it. Operations that read and then modify an entry hold the lock, so that they are atomic
+// across processes. Returns a no-op for the other cache types
+func (c *cache) lockEntry(key string) (func(), error) {
+	if c.cacheType != cacheTypeFile {
+		return func() {}, nil
+	}
+
+	hash := digest([]byte(key))
+	dir := filepath.Join(c.filePath, hash[0:2])
+	if err := c.perm.mkdirAll(dir); err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, c.perm.fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = unlockFile(file)
+		_ = file.Close()
+	}, nil
+}
+
+// Locks the entry of the key and writes its cache file
+func (c *cache) writeLockedCacheFile(key string, header fileHeader, val []byte) error {
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.writeCacheFile(key, header, val)
+}
+
+// Locks the entry of the key and removes its cache file
+func (c *cache) removeLockedCacheFile(key string) error {
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.removeCacheFile(key)
+}
+
+// Reads the cache file of the given key. Returns ErrCacheNotFound if it doesn't exist, removes it and returns
+// ErrCacheExpired if it expired, and quarantines it and returns ErrCacheCorrupted if it is corrupted
+func (c *cache) readValidCacheFile(key string) (fileHeader, []byte, error) {
+	header, value, err := c.readCacheFile(key)
+
+	return c.checkCacheFile(key, header, value, err)
+}
+
+// Checks the result of reading the cache file of the given key. Corrupted files are quarantined and expired files
+// removed
+func (c *cache) checkCacheFile(key string, header fileHeader, value []byte, err error) (fileHeader, []byte, error) {
+	if err == ErrCacheCorrupted {
+		c.quarantineCacheFile(key)
+		return header, nil, err
+	}
+
+	if err != nil {
+		return header, nil, ErrCacheNotFound
+	}
+
+	if header.expired() {
+		_ = c.removeCacheFile(key)
+		return header, nil, ErrCacheExpired
+	}
+
+	return header, value, nil
+}
+
+// Removes the cache file of the given key from the disk and the index if it expired. The entry is locked and its
+// header read again, so that a file that another process is rewriting is not removed
+func (c *cache) removeExpiredCacheFile(key string) {
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	header, err := c.readCacheFileHeader(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(c.cacheFiles, key)
+		}
+
+		return
+	}
+
+	if header.expired() {
+		if err := c.removeCacheFile(key); err == nil {
+			delete(c.cacheFiles, key)
+		}
+	}
+}
+
+// Moves a corrupted cache file to the quarantine directory, or removes it if quarantine is disabled
+func (c *cache) quarantineCacheFile(key string) {
+	atomic.AddUint64(&c.stats.corrupted, 1)
+
+	path := c.cacheFilePath(key)
+	if !c.quarantine {
+		_ = os.Remove(path)
+		return
+	}
+
+	dir := filepath.Join(c.filePath, quarantineDirName)
+	if err := c.perm.mkdirAll(dir); err != nil {
+		_ = os.Remove(path)
+		return
+	}
+
+	if err := os.Rename(path, filepath.Join(dir, fmt.Sprintf("%s-%d", filepath.Base(path), time.Now().UnixNano()))); err != nil {
+		_ = os.Remove(path)
+	}
+}
+
+// Writes the header and the value to the cache file of the given key. The content is written to a temporary file in
+// the same directory which is then renamed over the cache file, so readers never see a partially written value.
+// A new header gets the next version, a header that was read from the cache file keeps its version
+func (c *cache) writeCacheFile(key string, header fileHeader, val []byte) error {
+	if header.Version == 0 {
+		header.Version = c.nextFileVersion(key)
+	}
+
+	header.Format = fileFormatVersion
+	header.Checksum = crc32.Checksum(val, crc32c)
+
+	head, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.createTempFile(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(append(head, '\n'), val...)); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return c.commitTempFile(key, file)
+}
+
+// Returns the version of a new value of the key, which is compared by CompareAndSwap. Versions follow the current
+// time in nanoseconds, so that a key that is removed and written again does not repeat the version of a previous value
+func (c *cache) nextFileVersion(key string) uint64 {
+	version := uint64(time.Now().UnixNano())
+	if header, err := c.readCacheFileHeader(key); err == nil && header.Version >= version {
+		version = header.Version + 1
+	}
+
+	return version
+}
+
+// Creates a temporary file in the directory of the cache file of the given key
+func (c *cache) createTempFile(key string) (*os.File, error) {
+	dir := filepath.Dir(c.cacheFilePath(key))
+	if err := c.perm.mkdirAll(dir); err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	file, err := ioutil.TempFile(dir, tempFilePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	return file, nil
+}
+
+// Syncs the completely written temporary file depending on the durability, closes it and renames it over the cache
+// file of the given key. The temporary file is removed if any step fails
+func (c *cache) commitTempFile(key string, file *os.File) error {
+	if err := c.closeTempFile(file); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	path := c.cacheFilePath(key)
+	if err := os.Rename(file.Name(), path); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	c.recordAccess(key)
+
+	if c.durability >= DurabilityDirectory {
+		return syncDir(filepath.Dir(path))
+	}
+
+	return nil
+}
+
+// Syncs the temporary file depending on the durability, sets its permissions and closes it
+func (c *cache) closeTempFile(file *os.File) error {
+	if c.durability >= DurabilityFile {
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	if err := c.perm.apply(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Flushes the directory entries, which makes a rename in that directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Reads the header and the value from the cache file of the given key
+func (c *cache) readCacheFile(key string) (fileHeader, []byte, error) {
+	content, err := ioutil.ReadFile(c.cacheFilePath(key))
+	if err != nil {
+		return fileHeader{}, nil, err
+	}
+
+	return decodeCacheFile(content, key)
+}
+
+// Splits the content of a cache file into its header and value and verifies the checksum of the value
+func decodeCacheFile(content []byte, key string) (fileHeader, []byte, error) {
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return fileHeader{}, nil, ErrCacheCorrupted
+	}
+
+	header, err := parseFileHeader(content[:i], key)
+	if err != nil {
+		return header, nil, err
+	}
+
+	value := content[i+1:]
+	if crc32.Checksum(value, crc32c) != header.Checksum {
+		return header, nil, ErrCacheCorrupted
+	}
+
+	return header, value, nil
+}
+
+// Reads only the header of the cache file of the given key
+func (c *cache) readCacheFileHeader(key string) (fileHeader, error) {
+	return readFileHeader(c.cacheFilePath(key), key)
+}
+
+// Reads the header of the cache file at path and checks that it belongs to the key
+func readFileHeader(path, key string) (fileHeader, error) {
+	line, err := readHeaderLine(path)
+	if err != nil {
+		return fileHeader{}, err
+	}
+
+	return parseFileHeader(line, key)
+}
+
+// Returns the first line of the file at path
+func readHeaderLine(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return nil, ErrCacheCorrupted
+	}
+
+	return line, nil
+}
+
+// Scans the cache directory and adds the keys of the valid cache files to the index, so that the cache files written
+// before a restart are known to Flush and the cleaner. Expired files and stale temporary files are removed
+func (c *cache) loadCacheFiles() error {
+	return filepath.Walk(c.filePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == quarantineDirName {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Name() == lockFileName {
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), tempFilePrefix) {
+			if time.Since(info.ModTime()) > staleTempFileAge {
+				_ = os.Remove(path)
+			}
+
+			return nil
+		}
+
+		header, ok := c.readIndexHeader(path)
+		if !ok {
+			return nil
+		}
+
+		if header.expired() {
+			_ = os.Remove(path)
+			return nil
+		}
+
+		c.cacheFiles[header.Key] = struct{}{}
+		c.rememberExpiry(header.Key, fileExpiry{info: info, expires: header.Expires})
+
+		return nil
+	})
+}
+
+// Reads the header of a file found while scanning the cache directory. Files that are not stored at the path of the
+// key in their header are not cache files and are left alone
+func (c *cache) readIndexHeader(path string) (fileHeader, bool) {
+	line, err := readHeaderLine(path)
+	if err != nil {
+		return fileHeader{}, false
+	}
+
+	var header fileHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != fileFormatVersion {
+		return fileHeader{}, false
+	}
+
+	return header, c.cacheFilePath(header.Key) == filepath.Clean(path)
+}
+
+// Removes the cache file of the given key. A missing file is not an error
+func (c *cache) removeCacheFile(key string) error {
+	c.forgetExpiry(key)
+
+	if err := os.Remove(c.cacheFilePath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
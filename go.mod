@@ -6,4 +6,5 @@ require (
 	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
 	github.com/go-redis/redis/v7 v7.0.0-beta.4
 	github.com/stretchr/testify v1.4.0
+	go.etcd.io/bbolt v1.3.5
 )
@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Resets the time to live of the key if it has one. Persisted keys are not given an expiration
+var redisSlide = redis.NewScript(`
+if redis.call("PTTL", KEYS[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// This stores the fields in the hash of the given key without re-encoding its other fields, and resets the expiration
+// of the key to the expiration of the cache. Redis stores the fields in a hash, the memory cache in a nested map and
+// the file cache in a JSON document. Get and GetMulti return the fields as a JSON document on every cache type.
+// Returns ErrNotHash if the key holds a value that is not a hash and ErrNotSupported for the other cache types
+func (c *cache) HSetFields(key string, fields map[string]interface{}) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	encoded := make(map[string][]byte, len(fields))
+	for field, value := range fields {
+		val, err := json.MarshalIndent(value, "", " ")
+		if err != nil {
+			return err
+		}
+
+		encoded[field] = val
+	}
+
+	if len(encoded) == 0 {
+		return nil
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		return c.hSetDefaultCache(key, encoded)
+	case cacheTypeFile:
+		return c.hSetFileCache(key, encoded)
+	case cacheTypeRedis:
+		return c.hSetRedisCache(key, encoded)
+	}
+
+	return ErrNotSupported
+}
+
+// This returns the value of one field of the hash of the given key. Returns ErrCacheNotFound if the key or the field
+// doesn't exist. See HSetFields
+func (c *cache) HGetField(key string, field string) ([]byte, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	if c.cacheType == cacheTypeRedis {
+		c.flushBatch()
+
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+
+		return c.countLookup(c.hGetFieldRedisCache(key, field))
+	}
+
+	fields, err := c.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, found := fields[field]
+	if !found {
+		return nil, ErrCacheNotFound
+	}
+
+	return value, nil
+}
+
+// This returns all the fields of the hash of the given key. See HSetFields
+func (c *cache) HGetAll(key string) (map[string][]byte, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	var fields map[string][]byte
+	var err error
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		fields, err = c.hGetAllDefaultCache(key)
+	case cacheTypeFile:
+		var value []byte
+		if value, err = c.getFileCache(key, false); err == nil {
+			fields, err = decodeHash(value)
+		}
+	case cacheTypeRedis:
+		fields, err = c.hGetAllRedisCache(key)
+	default:
+		return nil, ErrNotSupported
+	}
+
+	_, err = c.countLookup(nil, err)
+
+	return fields, err
+}
+
+// Merges the fields into the nested map of the item. Items that were stored with Set are decoded once and keep only
+// their fields afterwards
+func (c *cache) hSetDefaultCache(key string, fields map[string][]byte) error {
+	now := time.Now().UnixNano()
+	item, found := c.items[key]
+	if found && item.expiration > 0 && now > item.expiration {
+		delete(c.items, key)
+		found = false
+	}
+
+	switch {
+	case !found:
+		if c.maxEntries > 0 && len(c.items) >= c.maxEntries {
+			c.evictOldest()
+		}
+
+		item = cacheItem{fields: make(map[string][]byte, len(fields))}
+	case item.fields == nil:
+		current, err := decodeHash(item.value)
+		if err != nil {
+			return err
+		}
+
+		item.fields = current
+	}
+
+	for field, val := range fields {
+		item.fields[field] = val
+	}
+
+	c.version++
+	item.value = nil
+	item.expiration = c.expiresAt()
+	item.created = now
+	item.version = c.version
+	c.items[key] = item
+
+	return nil
+}
+
+// Returns a copy of the fields of the item. Items that were stored with Set are decoded as a JSON document
+func (c *cache) hGetAllDefaultCache(key string) (map[string][]byte, error) {
+	item, err := c.getDefaultCacheItem(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.fields == nil {
+		return decodeHash(item.value)
+	}
+
+	fields := make(map[string][]byte, len(item.fields))
+	for field, val := range item.fields {
+		fields[field] = val
+	}
+
+	return fields, nil
+}
+
+// Merges the fields into the JSON document stored in the file
+func (c *cache) hSetFileCache(key string, fields map[string][]byte) error {
+	merged := make(map[string][]byte)
+	if _, value, err := c.readValidCacheFile(key); err == nil {
+		if merged, err = decodeHash(value); err != nil {
+			return err
+		}
+	}
+
+	for field, val := range fields {
+		merged[field] = val
+	}
+
+	document, err := encodeHash(merged)
+	if err != nil {
+		return err
+	}
+
+	if err := c.writeCacheFile(key, newFileHeader(key, c.expiresAt()), document); err != nil {
+		return err
+	}
+
+	c.cacheFiles[key] = struct{}{}
+
+	return nil
+}
+
+// Sets the fields of the redis hash and its expiration in a single transaction
+func (c *cache) hSetRedisCache(key string, fields map[string][]byte) error {
+	values := make(map[string]interface{}, len(fields))
+	for field, val := range fields {
+		values[field] = val
+	}
+
+	_, err := c.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(c.key(key), values)
+		if c.expiration > defaultExpiration {
+			pipe.Expire(c.key(key), c.expiration)
+		}
+
+		return nil
+	})
+
+	return redisHashError(err)
+}
+
+// Returns the field of the redis hash and slides its expiration in the same transaction
+func (c *cache) hGetFieldRedisCache(key string, field string) ([]byte, error) {
+	var cmd *redis.StringCmd
+
+	if _, err := c.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		cmd = pipe.HGet(c.key(key), field)
+		c.slideRedis(pipe, key)
+
+		return nil
+	}); err != nil {
+		return nil, redisHashError(err)
+	}
+
+	return []byte(cmd.Val()), nil
+}
+
+// Returns the fields of the redis hash and slides its expiration in the same transaction
+func (c *cache) hGetAllRedisCache(key string) (map[string][]byte, error) {
+	var cmd *redis.StringStringMapCmd
+
+	if _, err := c.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		cmd = pipe.HGetAll(c.key(key))
+		c.slideRedis(pipe, key)
+
+		return nil
+	}); err != nil {
+		return nil, redisHashError(err)
+	}
+
+	if len(cmd.Val()) == 0 {
+		return nil, ErrCacheNotFound
+	}
+
+	fields := make(map[string][]byte, len(cmd.Val()))
+	for field, val := range cmd.Val() {
+		fields[field] = []byte(val)
+	}
+
+	return fields, nil
+}
+
+// Returns the fields of the redis hash as a JSON document if the key holds a hash, so that Get reads the fields
+// stored with HSetFields like on the other cache types
+func (c *cache) getRedisHash(key string, removeCurrent bool, err error) ([]byte, error) {
+	if !strings.Contains(err.Error(), "WRONGTYPE") {
+		return nil, ErrCacheNotFound
+	}
+
+	fields, err := c.hGetAllRedisCache(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if removeCurrent {
+		_ = c.redisClient.Del(c.key(key))
+	}
+
+	return encodeHash(fields)
+}
+
+// Queues the reset of the expiration of the key if the cache has a sliding expiration
+func (c *cache) slideRedis(pipe redis.Pipeliner, key string) {
+	if c.sliding && c.expiration > defaultExpiration {
+		redisSlide.Eval(pipe, []string{c.key(key)}, c.expiration.Milliseconds())
+	}
+}
+
+// Maps the errors of the redis hash commands
+func redisHashError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == redis.Nil:
+		return ErrCacheNotFound
+	case strings.HasPrefix(err.Error(), "WRONGTYPE"):
+		return ErrNotHash
+	}
+
+	return err
+}
+
+// Decodes a JSON document into its fields. Returns ErrNotHash if the value is not a JSON object
+func decodeHash(value []byte) (map[string][]byte, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(value, &document); err != nil || document == nil {
+		return nil, ErrNotHash
+	}
+
+	fields := make(map[string][]byte, len(document))
+	for field, val := range document {
+		fields[field] = val
+	}
+
+	return fields, nil
+}
+
+// Encodes the fields into a JSON document
+func encodeHash(fields map[string][]byte) ([]byte, error) {
+	document := make(map[string]json.RawMessage, len(fields))
+	for field, val := range fields {
+		document[field] = val
+	}
+
+	return json.MarshalIndent(document, "", " ")
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
+const (
+	// Maximum length of a memcache key, including the prefix
+	memcacheMaxKeyLength = 250
+	// Marks a memcache key that was replaced by the digest of the original key
+	memcacheHashedKeyPrefix = "sha1-"
+	// Maximum length of a memcache prefix, so that hashed keys still fit
+	memcacheMaxPrefixLength = memcacheMaxKeyLength - len(memcacheHashedKeyPrefix) - 2*sha1.Size
+)
+
+// Returns ErrInvalidKey if the key cannot be stored by any cache type. Keys that are too long or contain characters
+// that memcache does not accept are hashed instead, so only the empty key is rejected
+func validateKey(key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+
+	return nil
+}
+
+// Returns the keys that are valid and reports the others in errs
+func validKeys(keys []string, errs MultiError) []string {
+	valid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := validateKey(key); err != nil {
+			errs[key] = err
+			continue
+		}
+
+		valid = append(valid, key)
+	}
+
+	return valid
+}
+
+// Returns the key as stored in memcache. Keys that are longer than memcache allows or contain spaces or control
+// characters are replaced by the prefix and the sha1 of the key
+func (c *cache) memcacheKey(key string) string {
+	if legalMemcacheKey(c.prefix + key) {
+		return c.prefix + key
+	}
+
+	return c.prefix + memcacheHashedKeyPrefix + digest([]byte(key))
+}
+
+// Returns ErrInvalidKey if the prefix contains characters that memcache does not accept or leaves no room for keys
+func validateMemcachePrefix(prefix string) error {
+	if !legalMemcacheKey(prefix) || len(prefix) > memcacheMaxPrefixLength {
+		return fmt.Errorf("%w: prefix %q cannot be used in memcache keys", ErrInvalidKey, prefix)
+	}
+
+	return nil
+}
+
+// Reports whether memcache accepts the key as is
+func legalMemcacheKey(key string) bool {
+	if len(key) > memcacheMaxKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
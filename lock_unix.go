@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive advisory lock on the file, waiting until other processes release it
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
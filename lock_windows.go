@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package cache
+
+import "os"
+
+// Advisory locks are not supported on windows, so the file cache is only safe within a single process
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,718 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	logDataExt = ".data"
+	logHintExt = ".hint"
+	// crc uint32, expires int64, flags uint8, key length uint32, value length uint32
+	logRecordHeaderSize = 4 + 8 + 1 + 4 + 4
+	// expires int64, key length uint32, value length uint32, offset int64
+	logHintHeaderSize = 8 + 4 + 4 + 8
+	logFlagTombstone  = 1
+	// An expiry record only holds the new expiration of the key, whose value stays in its previous record
+	logFlagExpiry = 2
+	// Compaction only runs once at least this many bytes of the log are dead
+	logMinCompactionBytes   = 1 << 20
+	defaultLogSegmentSize   = 64 << 20
+	logSegmentFileNameWidth = 10
+)
+
+// logEntry locates the latest record of a key in the log. The version is assigned when the record is indexed and
+// compared by CompareAndSwap. It is not stored in the log, since the log belongs to a single cache
+type logEntry struct {
+	segment int64
+	offset  int64
+	size    int64
+	expires int64
+	version uint64
+}
+
+func (e logEntry) expired() bool {
+	return e.expires > 0 && time.Now().UnixNano() > e.expires
+}
+
+// logStore is an append-only log of records split into segment files, with an in-memory index that points to the
+// latest record of each key (Bitcask). Deleted and overwritten records stay in the log until it is compacted
+type logStore struct {
+	mu          sync.Mutex
+	path        string
+	durability  Durability
+	segmentSize int64
+	perm        permissions
+	index       map[string]logEntry
+	version     uint64
+	segments    map[int64]*os.File
+	activeID    int64
+	activeSize  int64
+	liveBytes   int64
+	totalBytes  int64
+}
+
+// Opens the log in the directory, loading the index from the hint files or by scanning the segments. A torn record
+// at the end of the last segment, left by a crash, is truncated
+func openLogStore(path string, durability Durability, segmentSize int64, perm permissions) (*logStore, error) {
+	if err := perm.mkdirAll(path); err != nil {
+		return nil, fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	s := &logStore{
+		path:        path,
+		durability:  durability,
+		segmentSize: segmentSize,
+		perm:        perm,
+		index:       make(map[string]logEntry),
+		segments:    make(map[int64]*os.File),
+	}
+
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastHinted bool
+	for i, id := range ids {
+		file, err := os.OpenFile(s.segmentPath(id, logDataExt), os.O_RDWR, s.perm.fileMode)
+		if err != nil {
+			return nil, err
+		}
+
+		s.segments[id] = file
+
+		if err := s.loadHint(id); err == nil {
+			lastHinted = i == len(ids)-1
+			continue
+		}
+
+		if err := s.scanSegment(id, i == len(ids)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	var last int64
+	if len(ids) > 0 {
+		last = ids[len(ids)-1]
+	}
+
+	// An empty last segment is reused, so that opening the log without writing to it doesn't add a segment every
+	// time. A segment with a hint file is never appended to, since the hint would not cover the new records
+	if len(ids) > 0 && !lastHinted {
+		if info, err := s.segments[last].Stat(); err == nil && info.Size() == 0 {
+			_ = s.segments[last].Close()
+			delete(s.segments, last)
+			last--
+		}
+	}
+
+	if err := s.openSegment(last + 1); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Returns the ids of the segment files in ascending order
+func (s *logStore) segmentIDs() ([]int64, error) {
+	names, err := filepath.Glob(filepath.Join(s.path, "*"+logDataExt))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		id, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), logDataExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *logStore) segmentPath(id int64, ext string) string {
+	return filepath.Join(s.path, fmt.Sprintf("%0*d%s", logSegmentFileNameWidth, id, ext))
+}
+
+// Creates a new segment and makes it the one that records are appended to
+func (s *logStore) openSegment(id int64) error {
+	file, err := os.OpenFile(s.segmentPath(id, logDataExt), os.O_CREATE|os.O_RDWR|os.O_APPEND, s.perm.fileMode)
+	if err != nil {
+		return fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	if err := s.perm.apply(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if s.durability >= DurabilityDirectory {
+		if err := syncDir(s.path); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	s.segments[id] = file
+	s.activeID = id
+	s.activeSize = 0
+
+	return nil
+}
+
+// Adds the record to the index, replacing or deleting the previous record of the key
+func (s *logStore) apply(key string, entry logEntry, tombstone bool) {
+	s.totalBytes += entry.size
+
+	if previous, found := s.index[key]; found {
+		s.liveBytes -= previous.size
+		delete(s.index, key)
+	}
+
+	if tombstone || entry.expired() {
+		return
+	}
+
+	s.version++
+	entry.version = s.version
+	s.index[key] = entry
+	s.liveBytes += entry.size
+}
+
+// Updates the expiration of the key from an expiry record of the given size. The record itself is dead right away,
+// since the entry keeps pointing to the record of the value
+func (s *logStore) applyExpiry(key string, expires, size int64) {
+	s.totalBytes += size
+
+	entry, found := s.index[key]
+	if !found {
+		return
+	}
+
+	entry.expires = expires
+	if entry.expired() {
+		s.liveBytes -= entry.size
+		delete(s.index, key)
+		return
+	}
+
+	s.index[key] = entry
+}
+
+// Reads all the records of the segment into the index. A torn or corrupted record ends the segment; in the last
+// segment it is truncated, since it can only be a write that was interrupted by a crash
+func (s *logStore) scanSegment(id int64, last bool) error {
+	file := s.segments[id]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		key, _, expires, flags, size, err := readLogRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			if last {
+				return file.Truncate(offset)
+			}
+
+			return nil
+		}
+
+		if flags&logFlagExpiry != 0 {
+			s.applyExpiry(key, expires, size)
+		} else {
+			s.apply(key, logEntry{segment: id, offset: offset, size: size, expires: expires}, flags&logFlagTombstone != 0)
+		}
+
+		offset += size
+	}
+}
+
+// Loads the index entries of a compacted segment from its hint file, which avoids reading the values
+func (s *logStore) loadHint(id int64) error {
+	content, err := ioutil.ReadFile(s.segmentPath(id, logHintExt))
+	if err != nil {
+		return err
+	}
+
+	type hint struct {
+		key   string
+		entry logEntry
+	}
+
+	var hints []hint
+	for len(content) > 0 {
+		if len(content) < logHintHeaderSize {
+			return ErrCacheCorrupted
+		}
+
+		keyLen := int(binary.LittleEndian.Uint32(content[8:12]))
+		if len(content) < logHintHeaderSize+keyLen {
+			return ErrCacheCorrupted
+		}
+
+		hints = append(hints, hint{
+			key: string(content[logHintHeaderSize : logHintHeaderSize+keyLen]),
+			entry: logEntry{
+				segment: id,
+				offset:  int64(binary.LittleEndian.Uint64(content[16:24])),
+				size:    int64(binary.LittleEndian.Uint32(content[12:16])),
+				expires: int64(binary.LittleEndian.Uint64(content[0:8])),
+			},
+		})
+		content = content[logHintHeaderSize+keyLen:]
+	}
+
+	for _, h := range hints {
+		s.apply(h.key, h.entry, false)
+	}
+
+	return nil
+}
+
+// Reads one record. Returns io.EOF at the end of the log and ErrCacheCorrupted for a torn or corrupted record
+func readLogRecord(reader io.Reader) (key string, value []byte, expires int64, flags byte, size int64, err error) {
+	header := make([]byte, logRecordHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return "", nil, 0, 0, 0, io.EOF
+		}
+
+		return "", nil, 0, 0, 0, ErrCacheCorrupted
+	}
+
+	keyLen := binary.LittleEndian.Uint32(header[13:17])
+	valueLen := binary.LittleEndian.Uint32(header[17:21])
+
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return "", nil, 0, 0, 0, ErrCacheCorrupted
+	}
+
+	checksum := crc32.New(crc32c)
+	_, _ = checksum.Write(header[4:])
+	_, _ = checksum.Write(body)
+	if checksum.Sum32() != binary.LittleEndian.Uint32(header[0:4]) {
+		return "", nil, 0, 0, 0, ErrCacheCorrupted
+	}
+
+	expires = int64(binary.LittleEndian.Uint64(header[4:12]))
+	size = int64(logRecordHeaderSize + len(body))
+
+	return string(body[:keyLen]), body[keyLen:], expires, header[12], size, nil
+}
+
+// Encodes a record
+func encodeLogRecord(key string, value []byte, expires int64, flags byte) []byte {
+	record := make([]byte, logRecordHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint64(record[4:12], uint64(expires))
+	record[12] = flags
+	binary.LittleEndian.PutUint32(record[13:17], uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[17:21], uint32(len(value)))
+	copy(record[logRecordHeaderSize:], key)
+	copy(record[logRecordHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crc32c))
+
+	return record
+}
+
+// Appends the record to the active segment, starting a new segment if it is full
+func (s *logStore) append(record []byte) (logEntry, error) {
+	if s.activeSize > 0 && s.activeSize+int64(len(record)) > s.segmentSize {
+		if err := s.openSegment(s.activeID + 1); err != nil {
+			return logEntry{}, err
+		}
+	}
+
+	file := s.segments[s.activeID]
+	if _, err := file.Write(record); err != nil {
+		return logEntry{}, err
+	}
+
+	if s.durability >= DurabilityFile {
+		if err := file.Sync(); err != nil {
+			return logEntry{}, err
+		}
+	}
+
+	entry := logEntry{segment: s.activeID, offset: s.activeSize, size: int64(len(record))}
+	s.activeSize += entry.size
+
+	return entry, nil
+}
+
+// Closes the segment files. Returns the first error
+func (s *logStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	for id, file := range s.segments {
+		if err := file.Close(); err != nil && first == nil {
+			first = err
+		}
+
+		delete(s.segments, id)
+	}
+
+	return first
+}
+
+// Returns the value and the index entry of the key. Returns ErrCacheExpired and deletes the key if it expired
+func (s *logStore) get(key string) ([]byte, logEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.index[key]
+	if !found {
+		return nil, entry, ErrCacheNotFound
+	}
+
+	if entry.expired() {
+		_ = s.delete(key)
+		return nil, entry, ErrCacheExpired
+	}
+
+	_, value, _, _, _, err := readLogRecord(io.NewSectionReader(s.segments[entry.segment], entry.offset, entry.size))
+	if err != nil {
+		s.liveBytes -= entry.size
+		delete(s.index, key)
+		return nil, entry, ErrCacheCorrupted
+	}
+
+	return value, entry, nil
+}
+
+// Returns the index entry of the key if it exists and is not expired
+func (s *logStore) lookup(key string) (logEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.index[key]
+
+	return entry, found && !entry.expired()
+}
+
+// Stores the value for the key. expires is the unix time in nanoseconds at which it expires, 0 means never
+func (s *logStore) put(key string, value []byte, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.append(encodeLogRecord(key, value, expires, 0))
+	if err != nil {
+		return err
+	}
+
+	entry.expires = expires
+	s.apply(key, entry, false)
+
+	return nil
+}
+
+// Updates the expiration of the key by appending an expiry record, without rewriting its value. The version of the
+// key is kept
+func (s *logStore) touch(key string, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.index[key]
+	if !found {
+		return ErrCacheNotFound
+	}
+
+	if entry.expired() {
+		_ = s.delete(key)
+		return ErrCacheExpired
+	}
+
+	appended, err := s.append(encodeLogRecord(key, nil, expires, logFlagExpiry))
+	if err != nil {
+		return err
+	}
+
+	s.applyExpiry(key, expires, appended.size)
+
+	return nil
+}
+
+// Deletes the key by appending a tombstone for it
+func (s *logStore) remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(key)
+}
+
+func (s *logStore) delete(key string) error {
+	if _, found := s.index[key]; !found {
+		return nil
+	}
+
+	entry, err := s.append(encodeLogRecord(key, nil, 0, logFlagTombstone))
+	if err != nil {
+		return err
+	}
+
+	s.apply(key, entry, true)
+
+	return nil
+}
+
+// Deletes all the keys that start with prefix
+func (s *logStore) removePrefix(prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.index {
+		if strings.HasPrefix(key, prefix) {
+			if err := s.delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Removes all the segments and starts an empty log
+func (s *logStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, file := range s.segments {
+		_ = file.Close()
+		_ = os.Remove(s.segmentPath(id, logDataExt))
+		_ = os.Remove(s.segmentPath(id, logHintExt))
+	}
+
+	s.index = make(map[string]logEntry)
+	s.segments = make(map[int64]*os.File)
+	s.liveBytes = 0
+	s.totalBytes = 0
+
+	return s.openSegment(s.activeID + 1)
+}
+
+// Rewrites the live records of all the segments into a new segment with a hint file and removes the old segments,
+// which drops the overwritten, deleted and expired records. Only runs when at least half of the log is dead
+func (s *logStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.index {
+		if entry.expired() {
+			s.liveBytes -= entry.size
+			delete(s.index, key)
+		}
+	}
+
+	dead := s.totalBytes - s.liveBytes
+	if dead < logMinCompactionBytes || dead < s.totalBytes/2 {
+		return nil
+	}
+
+	oldSegments := s.segments
+	mergeID := s.activeID + 1
+
+	merge, err := os.OpenFile(s.segmentPath(mergeID, logDataExt), os.O_CREATE|os.O_RDWR|os.O_TRUNC, s.perm.fileMode)
+	if err != nil {
+		return fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	if err := s.perm.apply(merge); err != nil {
+		_ = merge.Close()
+		_ = os.Remove(merge.Name())
+		return err
+	}
+
+	index := make(map[string]logEntry, len(s.index))
+	var hint []byte
+	var offset int64
+
+	for key, entry := range s.index {
+		record := make([]byte, entry.size)
+		if _, err := oldSegments[entry.segment].ReadAt(record, entry.offset); err != nil {
+			continue
+		}
+
+		// The expiration may have been updated by expiry records since the value was written
+		if int64(binary.LittleEndian.Uint64(record[4:12])) != entry.expires {
+			binary.LittleEndian.PutUint64(record[4:12], uint64(entry.expires))
+			binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crc32c))
+		}
+
+		if _, err := merge.Write(record); err != nil {
+			_ = merge.Close()
+			_ = os.Remove(merge.Name())
+			return err
+		}
+
+		index[key] = logEntry{segment: mergeID, offset: offset, size: entry.size, expires: entry.expires, version: entry.version}
+		hint = append(hint, encodeLogHint(key, entry.expires, entry.size, offset)...)
+		offset += entry.size
+	}
+
+	if err := merge.Sync(); err != nil {
+		_ = merge.Close()
+		_ = os.Remove(merge.Name())
+		return err
+	}
+
+	if err := writeFileAtomically(s.segmentPath(mergeID, logHintExt), hint, s.perm); err != nil {
+		_ = merge.Close()
+		_ = os.Remove(merge.Name())
+		return err
+	}
+
+	// The new segment and its hint must be in the directory before the old segments are gone
+	if err := syncDir(s.path); err != nil {
+		_ = merge.Close()
+		_ = os.Remove(merge.Name())
+		_ = os.Remove(s.segmentPath(mergeID, logHintExt))
+		return err
+	}
+
+	s.segments = map[int64]*os.File{mergeID: merge}
+	s.index = index
+	s.liveBytes = offset
+	s.totalBytes = offset
+
+	// The old segments are removed in ascending order, so that the ones left by a crash are always the latest. An early
+	// segment without the later one that holds the tombstone of its key would bring the deleted key back
+	ids := make([]int64, 0, len(oldSegments))
+	for id := range oldSegments {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		_ = oldSegments[id].Close()
+		_ = os.Remove(s.segmentPath(id, logDataExt))
+		_ = os.Remove(s.segmentPath(id, logHintExt))
+	}
+
+	return s.openSegment(mergeID + 1)
+}
+
+// Encodes the hint of a record, which holds everything the index needs except the value
+func encodeLogHint(key string, expires, size, offset int64) []byte {
+	hint := make([]byte, logHintHeaderSize+len(key))
+	binary.LittleEndian.PutUint64(hint[0:8], uint64(expires))
+	binary.LittleEndian.PutUint32(hint[8:12], uint32(len(key)))
+	binary.LittleEndian.PutUint32(hint[12:16], uint32(size))
+	binary.LittleEndian.PutUint64(hint[16:24], uint64(offset))
+	copy(hint[logHintHeaderSize:], key)
+
+	return hint
+}
+
+// Writes the content to a temporary file with the given permissions that is synced and renamed to path
+func writeFileAtomically(path string, content []byte, perm permissions) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), tempFilePrefix)
+	if err != nil {
+		return err
+	}
+
+	if err := perm.apply(file); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// Returns value from log cache for given key. Removes current cache depending on second parameter
+func (c *cache) getLogCache(key string, removeCurrent bool) ([]byte, error) {
+	value, _, err := c.getLogCacheWithEntry(key, removeCurrent)
+
+	return value, err
+}
+
+// Returns the value and its index entry like getLogCache
+func (c *cache) getLogCacheWithEntry(key string, removeCurrent bool) ([]byte, logEntry, error) {
+	value, entry, err := c.logStore.get(key)
+	if err == ErrCacheCorrupted {
+		atomic.AddUint64(&c.stats.corrupted, 1)
+	}
+
+	if err != nil {
+		return nil, entry, err
+	}
+
+	if removeCurrent {
+		_ = c.logStore.remove(key)
+	} else if c.sliding && entry.expires > 0 {
+		expires := c.expiresAt()
+		if c.logStore.touch(key, expires) == nil {
+			entry.expires = expires
+		}
+	}
+
+	return value, entry, nil
+}
+
+// Updates the counter in the log. The expiration of an existing key is kept
+func (c *cache) incrLogCache(key string, delta int64) (int64, error) {
+	value, entry, err := c.logStore.get(key)
+	if err != nil {
+		if err := c.logStore.put(key, []byte(strconv.FormatInt(delta, 10)), c.expiresAt()); err != nil {
+			return 0, err
+		}
+
+		return delta, nil
+	}
+
+	current, err := parseCounter(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.logStore.put(key, []byte(strconv.FormatInt(current+delta, 10)), entry.expires); err != nil {
+		return 0, err
+	}
+
+	return current + delta, nil
+}
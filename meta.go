@@ -0,0 +1,397 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Lease is the result of GetWithLease
+type Lease struct {
+	// Value is the cached value. It is nil if the key was missing
+	Value []byte
+	// Win is true for the single caller that should recompute the value and store it with Set. The other callers get
+	// the stale value, or nil, until then
+	Win bool
+	// Stale is true if the value was invalidated or is being recomputed
+	Stale bool
+	// TTL is the remaining time to live of the value, or NoExpiration if it never expires
+	TTL time.Duration
+}
+
+// LeaseCache is implemented by the caches that hand out leases, which callers reach with a type assertion on a Cache.
+// The caches of this package implement it, but only the memcache meta protocol cache supports leases. The other cache
+// types return ErrNotSupported
+type LeaseCache interface {
+	GetWithLease(key string, ttl time.Duration) (Lease, error)
+	Invalidate(key string) error
+}
+
+// expiration time.Duration duration for cache to expire. 0*time.Second indicates the cache will never expire
+// servers []string list of memcache servers that speak the meta protocol (memcached 1.6 or newer)
+func NewMetaMemCache(expiration time.Duration, servers []string, opts ...Option) (Cache, error) {
+	o := newOptions(opts)
+	if expiration <= defaultExpiration {
+		expiration = defaultExpiration
+	}
+
+	if err := validateMemcachePrefix(o.prefix); err != nil {
+		return nil, err
+	}
+
+	selector, ring, err := newServerSelector(servers, o)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newMetaClient(selector)
+	if err := client.ping(); err != nil {
+		return nil, err
+	}
+
+	cache := &cache{
+		cacheType:  cacheTypeMetaMemcache,
+		expiration: expiration,
+		metaClient: client,
+		prefix:     o.prefix,
+		sliding:    o.sliding,
+		clock:      time.Now,
+	}
+
+	if ring != nil {
+		cache.runHealthChecks(ring, o.healthCheck)
+	}
+
+	return cache, nil
+}
+
+// This returns the value in the cache for the given key together with a lease, so that only one of many concurrent
+// callers recomputes a missing or invalidated value while the others keep serving the stale one. A missing key is
+// created empty for ttl, which bounds how long the winner may take before another caller wins.
+// Returns ErrInvalidTTL if ttl is not positive and ErrNotSupported for cache types other than the memcache meta protocol
+func (c *cache) GetWithLease(key string, ttl time.Duration) (Lease, error) {
+	if err := validateKey(key); err != nil {
+		return Lease{}, err
+	}
+
+	if c.cacheType != cacheTypeMetaMemcache {
+		return Lease{}, ErrNotSupported
+	}
+
+	// N0 would create an empty item that never expires, so a winner that fails to store the value blocks the key
+	if ttl <= defaultExpiration {
+		return Lease{}, ErrInvalidTTL
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result, err := c.metaClient.get(c.key(key), "v", "t", "N"+strconv.Itoa(int(c.memcacheExpiration(ttl))))
+	if err != nil {
+		return Lease{}, err
+	}
+
+	lease := Lease{
+		Win:   result.has('W'),
+		Stale: result.has('X'),
+		TTL:   metaTTL(result),
+	}
+
+	// An item that was created by N is empty until the winner stores the value
+	if len(result.value) > 0 {
+		if lease.Value, err = c.readChunked(key, result.value, nil, false); err != nil {
+			return Lease{}, err
+		}
+	}
+
+	return lease, nil
+}
+
+// This marks the cache for the given key as stale instead of deleting it. The next GetWithLease wins the right to
+// recompute it, while the others keep getting the stale value.
+// Returns ErrNotSupported for cache types other than the memcache meta protocol
+func (c *cache) Invalidate(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if c.cacheType != cacheTypeMetaMemcache {
+		return ErrNotSupported
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result, err := c.metaClient.delete(c.key(key), "I")
+	if err != nil {
+		return err
+	}
+
+	if result.status == metaStatusNotFound {
+		return ErrCacheNotFound
+	}
+
+	return nil
+}
+
+// Stores the value with ms. Values over the item size limit are stored in chunks like in memcache
+func (c *cache) setMetaCache(key string, val []byte) error {
+	if len(val) > memcacheMaxValueSize {
+		return c.setChunkedStream(key, bytes.NewReader(val))
+	}
+
+	result, err := c.metaClient.set(c.key(key), val, c.metaTTLFlag(c.expiration))
+	if err != nil {
+		return err
+	}
+
+	return metaStatusError(result, metaStatusHit)
+}
+
+// Stores the value with ms in add mode, which fails if the key exists
+func (c *cache) addMetaCache(key string, val []byte) error {
+	stored, written, err := c.chunkLargeValue(key, val)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.metaClient.set(c.key(key), stored, c.metaTTLFlag(c.expiration), "ME")
+	if err == nil {
+		err = metaStatusError(result, metaStatusHit)
+	}
+
+	if err != nil && written != nil {
+		c.deleteChunks(key, *written)
+	}
+
+	return err
+}
+
+// Runs mg for a read that does not ask for a lease. Memcache gives the win to the first mg of a stale item whatever
+// its flags are, so a read that won invalidates the item again with its CAS token to hand the win to the next
+// GetWithLease
+func (c *cache) getMetaItem(key string, flags ...string) (metaResult, error) {
+	result, err := c.metaClient.get(c.key(key), append(flags, "c")...)
+	if err == nil {
+		c.returnMetaLease(key, result)
+	}
+
+	return result, err
+}
+
+// Hands back the win that a read without a lease was given
+func (c *cache) returnMetaLease(key string, result metaResult) {
+	if result.has('W') && result.has('X') {
+		_, _ = c.metaClient.delete(c.key(key), "I", "C"+result.flags['c'])
+	}
+}
+
+// Returns value from the memcache meta cache for given key. Removes current cache depending on second parameter.
+// A sliding expiration is updated in the same request. Stale items and items that are being recomputed after a
+// GetWithLease are reported as expired
+func (c *cache) getMetaCache(key string, removeCurrent bool) ([]byte, error) {
+	flags := []string{"v"}
+	if c.sliding && !removeCurrent && c.expiration > defaultExpiration {
+		flags = append(flags, c.metaTTLFlag(c.expiration))
+	}
+
+	result, err := c.getMetaItem(key, flags...)
+	if err != nil || result.status != metaStatusValue {
+		return nil, ErrCacheNotFound
+	}
+
+	if result.has('X') || result.has('W') || result.has('Z') {
+		return nil, ErrCacheExpired
+	}
+
+	if removeCurrent {
+		_, _ = c.metaClient.delete(c.key(key))
+	}
+
+	return result.value, nil
+}
+
+// Reports whether a valid item exists without fetching its value
+func (c *cache) hasMetaCache(key string) bool {
+	result, err := c.getMetaItem(key)
+	if err != nil || result.status != metaStatusHit {
+		return false
+	}
+
+	return !result.has('X') && !result.has('W') && !result.has('Z')
+}
+
+// Increments or decrements the counter with ma. A missing counter is created with the expiration of the cache
+func (c *cache) incrMetaCache(key string, delta int64) (int64, error) {
+	mode, initial := "MI", delta
+	if delta < 0 {
+		mode, initial, delta = "MD", 0, -delta
+	}
+
+	result, err := c.metaClient.arithmetic(c.key(key), "v", mode, "D"+strconv.FormatInt(delta, 10),
+		"J"+strconv.FormatInt(initial, 10), "N"+strconv.Itoa(int(c.memcacheExpiration(c.expiration))))
+	if err == errMetaNonNumeric {
+		return 0, ErrNotInteger
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if result.status != metaStatusValue {
+		return 0, metaStatusError(result, metaStatusValue)
+	}
+
+	return parseCounter(result.value)
+}
+
+// Returns the value and its CAS token
+func (c *cache) getWithVersionMetaCache(key string) ([]byte, Version, error) {
+	result, err := c.getMetaItem(key, "v")
+	if err != nil || result.status != metaStatusValue || result.has('X') || result.has('Z') {
+		return nil, Version{}, ErrCacheNotFound
+	}
+
+	value, err := c.readChunked(key, result.value, nil, false)
+	if err != nil {
+		return nil, Version{}, err
+	}
+
+	version := Version{token: result.flags['c']}
+	if manifest, ok := parseStreamManifest(result.value); ok {
+		version.manifest = &manifest
+	}
+
+	return value, version, nil
+}
+
+// Stores the value with ms if the CAS token is unchanged. Large values are written in chunks first and only their
+// manifest is swapped. The chunks of whichever value lost are removed
+func (c *cache) compareAndSwapMetaCache(key string, version Version, val []byte) error {
+	if version.token == "" {
+		return ErrCASConflict
+	}
+
+	stored, written, err := c.chunkLargeValue(key, val)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.metaClient.set(c.key(key), stored, c.metaTTLFlag(c.expiration), "C"+version.token)
+	if err == nil {
+		err = metaStatusError(result, metaStatusHit)
+	}
+
+	if err != nil && written != nil {
+		c.deleteChunks(key, *written)
+	}
+
+	if err == nil && version.manifest != nil {
+		c.deleteChunks(key, *version.manifest)
+	}
+
+	return err
+}
+
+// Returns the remaining time to live of the item
+func (c *cache) ttlMetaCache(key string) (time.Duration, error) {
+	result, err := c.getMetaItem(key, "t")
+	if err != nil {
+		return 0, err
+	}
+
+	if result.status != metaStatusHit {
+		return 0, ErrCacheNotFound
+	}
+
+	return metaTTL(result), nil
+}
+
+// Updates the expiration of the item without fetching it
+func (c *cache) touchMetaCache(key string, ttl time.Duration) error {
+	result, err := c.getMetaItem(key, c.metaTTLFlag(ttl))
+	if err != nil {
+		return err
+	}
+
+	if result.status != metaStatusHit {
+		return ErrCacheNotFound
+	}
+
+	return nil
+}
+
+// Returns the values of the keys, fetched with one pipelined request per server. A sliding expiration is updated in the
+// same request
+func (c *cache) getMultiMetaCache(keys []string, values map[string][]byte, errs MultiError) {
+	storeKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storeKeys[i] = c.key(key)
+	}
+
+	flags := []string{"v", "c"}
+	if c.sliding && c.expiration > defaultExpiration {
+		flags = append(flags, c.metaTTLFlag(c.expiration))
+	}
+
+	results, err := c.metaClient.getMulti(storeKeys, flags...)
+	if err != nil {
+		for _, key := range keys {
+			errs[key] = err
+		}
+
+		return
+	}
+
+	for _, key := range keys {
+		result, found := results[c.key(key)]
+		if found {
+			c.returnMetaLease(key, result)
+		}
+
+		if !found || result.has('X') || result.has('W') || result.has('Z') {
+			errs[key] = ErrCacheNotFound
+			continue
+		}
+
+		value, err := c.readChunked(key, result.value, nil, false)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		values[key] = value
+	}
+}
+
+// Returns the T flag that sets the ttl of an item
+func (c *cache) metaTTLFlag(ttl time.Duration) string {
+	return "T" + strconv.Itoa(int(c.memcacheExpiration(ttl)))
+}
+
+// Returns the ttl from the t flag of a response, which is -1 for items that never expire
+func metaTTL(result metaResult) time.Duration {
+	seconds, err := strconv.Atoi(result.flags['t'])
+	if err != nil || seconds < 0 {
+		return NoExpiration
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Maps an unexpected status of a meta response to an error
+func metaStatusError(result metaResult, expected string) error {
+	switch result.status {
+	case expected:
+		return nil
+	case metaStatusNotFound, metaStatusMiss:
+		return ErrCacheNotFound
+	case metaStatusExists:
+		return ErrCASConflict
+	case metaStatusNotStore:
+		return ErrCacheAlreadyExists
+	}
+
+	return fmt.Errorf("memcache: unexpected response %q", result.status)
+}
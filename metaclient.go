@@ -0,0 +1,364 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Maximum number of idle connections that the meta client keeps per server
+const metaMaxIdleConns = 2
+
+// Status codes of the memcache meta protocol
+const (
+	metaStatusValue    = "VA"
+	metaStatusHit      = "HD"
+	metaStatusMiss     = "EN"
+	metaStatusNotFound = "NF"
+	metaStatusNotStore = "NS"
+	metaStatusExists   = "EX"
+	metaStatusNoop     = "MN"
+)
+
+var (
+	errMetaNonNumeric     = errors.New("memcache: cannot increment or decrement non-numeric value")
+	errMetaUnknownCommand = errors.New("memcache: server does not support the meta protocol")
+)
+
+// metaClient speaks the memcache meta protocol (mg, ms, md, ma and mn), which gomemcache does not support
+type metaClient struct {
+	selector memcache.ServerSelector
+	timeout  time.Duration
+	mu       sync.Mutex
+	idle     map[string][]*metaConn
+}
+
+type metaConn struct {
+	nc   net.Conn
+	rw   *bufio.ReadWriter
+	addr net.Addr
+}
+
+// metaResult is the response to a meta command. Flags holds the returned flags by their letter
+type metaResult struct {
+	status string
+	value  []byte
+	flags  map[byte]string
+}
+
+func newMetaClient(selector memcache.ServerSelector) *metaClient {
+	return &metaClient{
+		selector: selector,
+		timeout:  memcache.DefaultTimeout,
+		idle:     make(map[string][]*metaConn),
+	}
+}
+
+func (r metaResult) has(flag byte) bool {
+	_, found := r.flags[flag]
+	return found
+}
+
+// Returns the connection to the server or opens a new one
+func (m *metaClient) conn(addr net.Addr) (*metaConn, error) {
+	m.mu.Lock()
+	if conns := m.idle[addr.String()]; len(conns) > 0 {
+		cn := conns[len(conns)-1]
+		m.idle[addr.String()] = conns[:len(conns)-1]
+		m.mu.Unlock()
+		return cn, nil
+	}
+	m.mu.Unlock()
+
+	nc, err := net.DialTimeout(addr.Network(), addr.String(), m.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metaConn{
+		nc:   nc,
+		rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+		addr: addr,
+	}, nil
+}
+
+// Returns the connection to the idle pool, or closes it if the command failed in a way that may have left unread
+// data on it. A server without the meta protocol answers the value of ms with a second ERROR, so those connections
+// are closed too
+func (m *metaClient) release(cn *metaConn, err error) {
+	if err != nil && err != errMetaNonNumeric {
+		_ = cn.nc.Close()
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.idle[cn.addr.String()]) >= metaMaxIdleConns {
+		_ = cn.nc.Close()
+		return
+	}
+
+	m.idle[cn.addr.String()] = append(m.idle[cn.addr.String()], cn)
+}
+
+// Closes the idle connections
+func (m *metaClient) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for addr, conns := range m.idle {
+		for _, cn := range conns {
+			_ = cn.nc.Close()
+		}
+
+		delete(m.idle, addr)
+	}
+}
+
+// Runs fn with a connection to the given server
+func (m *metaClient) withAddr(addr net.Addr, fn func(*metaConn) error) error {
+	cn, err := m.conn(addr)
+	if err != nil {
+		return err
+	}
+
+	if err := cn.nc.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		m.release(cn, err)
+		return err
+	}
+
+	err = fn(cn)
+	m.release(cn, err)
+
+	return err
+}
+
+// Sends a single meta command for the key to the server that owns it and returns the response
+func (m *metaClient) do(cmd, key string, value []byte, flags ...string) (metaResult, error) {
+	addr, err := m.selector.PickServer(key)
+	if err != nil {
+		return metaResult{}, err
+	}
+
+	var result metaResult
+	err = m.withAddr(addr, func(cn *metaConn) error {
+		if err := cn.write(cmd, key, value, flags); err != nil {
+			return err
+		}
+
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+
+		result, err = cn.read()
+		return err
+	})
+
+	return result, err
+}
+
+// get runs mg
+func (m *metaClient) get(key string, flags ...string) (metaResult, error) {
+	return m.do("mg", key, nil, flags...)
+}
+
+// set runs ms with the value
+func (m *metaClient) set(key string, value []byte, flags ...string) (metaResult, error) {
+	return m.do("ms", key, value, flags...)
+}
+
+// delete runs md
+func (m *metaClient) delete(key string, flags ...string) (metaResult, error) {
+	return m.do("md", key, nil, flags...)
+}
+
+// arithmetic runs ma
+func (m *metaClient) arithmetic(key string, flags ...string) (metaResult, error) {
+	return m.do("ma", key, nil, flags...)
+}
+
+// Fetches the keys with one pipeline per server. Every mg carries an opaque token to match the responses, misses
+// are suppressed with the quiet flag and mn marks the end of the responses
+func (m *metaClient) getMulti(keys []string, flags ...string) (map[string]metaResult, error) {
+	byAddr := make(map[string][]string)
+	addrs := make(map[string]net.Addr)
+
+	for _, key := range keys {
+		addr, err := m.selector.PickServer(key)
+		if err != nil {
+			return nil, err
+		}
+
+		byAddr[addr.String()] = append(byAddr[addr.String()], key)
+		addrs[addr.String()] = addr
+	}
+
+	results := make(map[string]metaResult, len(keys))
+
+	for name, keys := range byAddr {
+		if err := m.withAddr(addrs[name], func(cn *metaConn) error {
+			for i, key := range keys {
+				if err := cn.write("mg", key, nil, append(append([]string{}, flags...), "q", "O"+strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+
+			if _, err := cn.rw.WriteString("mn\r\n"); err != nil {
+				return err
+			}
+
+			if err := cn.rw.Flush(); err != nil {
+				return err
+			}
+
+			for {
+				result, err := cn.read()
+				if err != nil {
+					return err
+				}
+
+				if result.status == metaStatusNoop {
+					return nil
+				}
+
+				i, err := strconv.Atoi(result.flags['O'])
+				if err != nil || i < 0 || i >= len(keys) {
+					return fmt.Errorf("memcache: unexpected opaque %q", result.flags['O'])
+				}
+
+				results[keys[i]] = result
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Sends mn to every server and waits for the answer
+func (m *metaClient) ping() error {
+	return m.selector.Each(func(addr net.Addr) error {
+		return m.withAddr(addr, func(cn *metaConn) error {
+			return cn.simple("mn\r\n", metaStatusNoop)
+		})
+	})
+}
+
+// Removes all the items from every server
+func (m *metaClient) flushAll() error {
+	return m.selector.Each(func(addr net.Addr) error {
+		return m.withAddr(addr, func(cn *metaConn) error {
+			return cn.simple("flush_all\r\n", "OK")
+		})
+	})
+}
+
+// Writes a command line and, for ms, the value
+func (cn *metaConn) write(cmd, key string, value []byte, flags []string) error {
+	line := cmd + " " + key
+	if cmd == "ms" {
+		line += " " + strconv.Itoa(len(value))
+	}
+
+	if len(flags) > 0 {
+		line += " " + strings.Join(flags, " ")
+	}
+
+	if _, err := cn.rw.WriteString(line + "\r\n"); err != nil {
+		return err
+	}
+
+	if cmd != "ms" {
+		return nil
+	}
+
+	if _, err := cn.rw.Write(value); err != nil {
+		return err
+	}
+
+	_, err := cn.rw.WriteString("\r\n")
+
+	return err
+}
+
+// Reads one response, including the value of a VA response
+func (cn *metaConn) read() (metaResult, error) {
+	line, err := cn.rw.ReadSlice('\n')
+	if err != nil {
+		return metaResult{}, err
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return metaResult{}, fmt.Errorf("memcache: empty response")
+	}
+
+	switch {
+	case fields[0] == "CLIENT_ERROR" && strings.Contains(string(line), "non-numeric"):
+		return metaResult{}, errMetaNonNumeric
+	case fields[0] == "ERROR":
+		return metaResult{}, errMetaUnknownCommand
+	case strings.HasSuffix(fields[0], "_ERROR"):
+		return metaResult{}, fmt.Errorf("memcache: %s", bytes.TrimSpace(line))
+	}
+
+	result := metaResult{status: fields[0], flags: make(map[byte]string)}
+
+	if result.status == metaStatusValue {
+		if len(fields) < 2 {
+			return metaResult{}, fmt.Errorf("memcache: malformed response %q", bytes.TrimSpace(line))
+		}
+
+		size, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return metaResult{}, fmt.Errorf("memcache: malformed response %q", bytes.TrimSpace(line))
+		}
+
+		fields = fields[1:]
+		result.value = make([]byte, size+2)
+		if _, err := io.ReadFull(cn.rw, result.value); err != nil {
+			return metaResult{}, err
+		}
+
+		result.value = result.value[:size]
+	}
+
+	for _, flag := range fields[1:] {
+		result.flags[flag[0]] = flag[1:]
+	}
+
+	return result, nil
+}
+
+// Sends a command that is answered with a single line and checks the answer
+func (cn *metaConn) simple(command, expected string) error {
+	if _, err := cn.rw.WriteString(command); err != nil {
+		return err
+	}
+
+	if err := cn.rw.Flush(); err != nil {
+		return err
+	}
+
+	line, err := cn.rw.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+
+	if got := string(bytes.TrimSpace(line)); got != expected {
+		return fmt.Errorf("memcache: unexpected response %q", got)
+	}
+
+	return nil
+}
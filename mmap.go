@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Size from which GetMapped maps the files of the file cache when no threshold is configured
+const defaultMmapThreshold = 1 << 20
+
+// MappedValue is a read-only view of a cached value. Values of the file cache that are at least as large as the mmap
+// threshold are backed by a memory mapping of the cache file instead of a copy on the heap. Cache files are replaced
+// by renaming, never modified in place, so the view stays valid even if the key is set or deleted meanwhile.
+// Release must be called once the value is no longer used. The bytes must not be modified or used after Release
+type MappedValue struct {
+	mu      sync.Mutex
+	data    []byte
+	mapping []byte
+}
+
+// Bytes returns the value. The slice is only valid until Release is called
+func (v *MappedValue) Bytes() []byte {
+	return v.data
+}
+
+// Len returns the length of the value
+func (v *MappedValue) Len() int {
+	return len(v.data)
+}
+
+// ReadAt implements io.ReaderAt
+func (v *MappedValue) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if off >= int64(len(v.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, v.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Release unmaps the value. It is safe to call Release more than once
+func (v *MappedValue) Release() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	mapping := v.mapping
+	v.data = nil
+	v.mapping = nil
+
+	if mapping == nil {
+		return nil
+	}
+
+	return unmapFile(mapping)
+}
+
+// This returns a read-only view of the value in the cache for the given key. Large values of the file cache are
+// memory mapped, the other cache types return a copy. Returns error if cache doesn't exist or expired
+func (c *cache) GetMapped(key string) (*MappedValue, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	if c.cacheType == cacheTypeFile {
+		value, err := c.getMappedFileCache(key)
+		if err != nil {
+			_, err = c.countLookup(nil, err)
+			return nil, err
+		}
+
+		_, _ = c.countLookup(value.data, nil)
+
+		return value, nil
+	}
+
+	value, err := c.countLookup(c.get(key, false))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MappedValue{data: value}, nil
+}
+
+// Returns the value of the file cache for the given key, mapping the cache file if it reaches the mmap threshold
+func (c *cache) getMappedFileCache(key string) (*MappedValue, error) {
+	info, err := os.Stat(c.cacheFilePath(key))
+	if err != nil {
+		return nil, ErrCacheNotFound
+	}
+
+	if info.Size() < c.mmapThreshold {
+		value, err := c.getFileCache(key, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &MappedValue{data: value}, nil
+	}
+
+	if c.sliding {
+		unlock, err := c.lockEntry(key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	mapping, err := c.mapCacheFile(key)
+	if err != nil {
+		return nil, ErrCacheNotFound
+	}
+
+	header, value, err := decodeCacheFile(mapping, key)
+	header, value, err = c.checkCacheFile(key, header, value, err)
+	if err != nil {
+		_ = unmapFile(mapping)
+		return nil, err
+	}
+
+	if c.sliding && header.Expires > 0 {
+		header.Expires = c.expiresAt()
+		_ = c.writeCacheFile(key, header, value)
+	}
+
+	c.recordAccess(key)
+
+	return &MappedValue{data: value, mapping: mapping}, nil
+}
+
+// Maps the whole cache file of the given key into memory
+func (c *cache) mapCacheFile(key string) ([]byte, error) {
+	file, err := os.Open(c.cacheFilePath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		return nil, ErrCacheNotFound
+	}
+
+	return mapFile(file, int(info.Size()))
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package cache
+
+import (
+	"io"
+	"os"
+)
+
+// Memory mappings are not supported on windows, so the file is read into memory instead
+func mapFile(file *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
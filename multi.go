@@ -0,0 +1,421 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v7"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The file cache runs at most this many goroutines per batch operation, since each of them holds an open file and a
+// lock
+const fileMultiConcurrency = 16
+
+// MultiError is returned by the batch operations. It holds the error for each key that failed
+type MultiError map[string]error
+
+func (m MultiError) Error() string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %v", key, m[key]))
+	}
+
+	return "cache lib: " + strings.Join(messages, "; ")
+}
+
+// Returns nil if there are no errors so that the result can be returned as error directly
+func (m MultiError) errOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return m
+}
+
+// This returns the valid values in the cache for the given keys. Keys that are missing, expired or failed are
+// left out of the result and reported in the returned MultiError
+func (c *cache) GetMulti(keys []string) (map[string][]byte, error) {
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	values := make(map[string][]byte, len(keys))
+	errs := make(MultiError)
+	keys = validKeys(keys, errs)
+
+	switch c.cacheType {
+	case cacheTypeDefault, cacheTypeLog, cacheTypeBolt:
+		for _, key := range keys {
+			value, err := c.get(key, false)
+			if err != nil {
+				errs[key] = err
+				continue
+			}
+
+			values[key] = value
+		}
+	case cacheTypeFile:
+		var mu sync.Mutex
+
+		forEachFile(keys, func(key string) {
+			value, err := c.getFileCache(key, false)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs[key] = err
+				return
+			}
+
+			values[key] = value
+		})
+	case cacheTypeRedis:
+		if len(keys) == 0 {
+			break
+		}
+
+		storeKeys := make([]string, len(keys))
+		for i, key := range keys {
+			storeKeys[i] = c.key(key)
+		}
+
+		result, err := c.redisClient.MGet(storeKeys...).Result()
+		if err != nil {
+			for _, key := range keys {
+				errs[key] = err
+			}
+			break
+		}
+
+		var missed []string
+		for i, key := range keys {
+			value, ok := result[i].(string)
+			if !ok {
+				missed = append(missed, key)
+				continue
+			}
+
+			chunked, err := c.readChunked(key, []byte(value), nil, false)
+			if err != nil {
+				errs[key] = err
+				continue
+			}
+
+			values[key] = chunked
+		}
+
+		c.getMultiRedisHashes(missed, values, errs)
+
+		// MGET doesn't slide the expiration like Get does, so the expirations of the hits are reset in one pipeline
+		if c.sliding && c.expiration > defaultExpiration && len(values) > 0 {
+			_, _ = c.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+				for key := range values {
+					c.slideRedis(pipe, key)
+				}
+
+				return nil
+			})
+		}
+	case cacheTypeMetaMemcache:
+		c.getMultiMetaCache(keys, values, errs)
+	case cacheTypeMemcache:
+		storeKeys := make([]string, len(keys))
+		for i, key := range keys {
+			storeKeys[i] = c.key(key)
+		}
+
+		items, err := c.memCacheClient.GetMulti(storeKeys)
+		if err != nil {
+			for _, key := range keys {
+				errs[key] = err
+			}
+			break
+		}
+
+		for _, key := range keys {
+			item, found := items[c.key(key)]
+			if !found {
+				errs[key] = ErrCacheNotFound
+				continue
+			}
+
+			value, err := c.readChunked(key, item.Value, nil, false)
+			if err != nil {
+				errs[key] = err
+				continue
+			}
+
+			if c.sliding && c.expiration > defaultExpiration {
+				_ = c.memCacheClient.Touch(c.key(key), c.memcacheExpiration(c.expiration))
+			}
+
+			values[key] = value
+		}
+	}
+
+	return values, errs.errOrNil()
+}
+
+// This sets all the given values to their keys, overriding the existing values. Keys that could not be stored are
+// reported in the returned MultiError
+func (c *cache) SetMulti(items map[string]interface{}) error {
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make(map[string][]byte, len(items))
+	errs := make(MultiError)
+
+	for key, value := range items {
+		if err := validateKey(key); err != nil {
+			errs[key] = err
+			continue
+		}
+
+		val, err := json.MarshalIndent(value, "", " ")
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		values[key] = val
+	}
+
+	switch c.cacheType {
+	case cacheTypeDefault, cacheTypeMemcache, cacheTypeMetaMemcache, cacheTypeLog:
+		for key, value := range values {
+			if err := c.replaceBytes(key, value); err != nil {
+				errs[key] = err
+			}
+		}
+	case cacheTypeFile:
+		var mu sync.Mutex
+		expiration := c.expiresAt()
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+
+		forEachFile(keys, func(key string) {
+			err := c.writeLockedCacheFile(key, newFileHeader(key, expiration), values[key])
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs[key] = err
+				return
+			}
+
+			c.cacheFiles[key] = struct{}{}
+		})
+	case cacheTypeBolt:
+		expiration := c.expiresAt()
+
+		if err := c.boltDB.Update(func(tx *bolt.Tx) error {
+			for key, value := range values {
+				if err := c.boltPut(tx, key, value, expiration); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+			for key := range values {
+				errs[key] = err
+			}
+		}
+	case cacheTypeRedis:
+		if len(values) == 0 {
+			break
+		}
+
+		cmds := make(map[string]*redis.Cmd, len(values))
+		_, _ = c.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+			for key, value := range values {
+				cmds[key] = replaceRedis(pipe, c.key(key), value, c.expiration)
+			}
+
+			return nil
+		})
+
+		c.deleteReplacedChunks(cmds, errs)
+	}
+
+	return errs.errOrNil()
+}
+
+// This deletes the cache for all the given keys. Keys that could not be deleted are reported in the returned MultiError
+func (c *cache) DeleteMulti(keys []string) error {
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := make(MultiError)
+	keys = validKeys(keys, errs)
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		for _, key := range keys {
+			delete(c.items, key)
+		}
+	case cacheTypeFile:
+		var mu sync.Mutex
+
+		forEachFile(keys, func(key string) {
+			err := c.removeLockedCacheFile(key)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs[key] = err
+				return
+			}
+
+			delete(c.cacheFiles, key)
+		})
+	case cacheTypeRedis:
+		if len(keys) == 0 {
+			break
+		}
+
+		cmds := make(map[string]*redis.Cmd, len(keys))
+		_, _ = c.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				cmds[key] = replaceRedis(pipe, c.key(key), nil, 0)
+			}
+
+			return nil
+		})
+
+		c.deleteReplacedChunks(cmds, errs)
+	case cacheTypeMemcache:
+		for _, key := range keys {
+			c.deleteChunkedValue(key)
+			if err := c.memCacheClient.Delete(c.key(key)); err != nil && err != memcache.ErrCacheMiss {
+				errs[key] = err
+			}
+		}
+	case cacheTypeMetaMemcache:
+		for _, key := range keys {
+			c.deleteChunkedValue(key)
+			if _, err := c.metaClient.delete(c.key(key)); err != nil {
+				errs[key] = err
+			}
+		}
+	case cacheTypeLog:
+		for _, key := range keys {
+			if err := c.logStore.remove(key); err != nil {
+				errs[key] = err
+			}
+		}
+	case cacheTypeBolt:
+		if err := c.boltDB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(c.boltBucket)
+			for _, key := range keys {
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+			for _, key := range keys {
+				errs[key] = err
+			}
+		}
+	}
+
+	return errs.errOrNil()
+}
+
+// Returns the hashes of the keys as JSON documents like Get, fetched with one pipeline. MGET returns nil for the keys
+// that hold a hash, so this reads the keys that it missed
+func (c *cache) getMultiRedisHashes(keys []string, values map[string][]byte, errs MultiError) {
+	if len(keys) == 0 {
+		return
+	}
+
+	cmds := make(map[string]*redis.StringStringMapCmd, len(keys))
+	_, _ = c.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds[key] = pipe.HGetAll(c.key(key))
+		}
+
+		return nil
+	})
+
+	for key, cmd := range cmds {
+		if cmd.Err() != nil || len(cmd.Val()) == 0 {
+			errs[key] = ErrCacheNotFound
+			continue
+		}
+
+		fields := make(map[string][]byte, len(cmd.Val()))
+		for field, val := range cmd.Val() {
+			fields[field] = []byte(val)
+		}
+
+		document, err := encodeHash(fields)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		values[key] = document
+	}
+}
+
+// Records the errors of the redisReplace commands and removes the chunks of the streamed values that they replaced
+func (c *cache) deleteReplacedChunks(cmds map[string]*redis.Cmd, errs MultiError) {
+	for key, cmd := range cmds {
+		manifest, err := replacedManifest(cmd)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		if manifest != nil {
+			c.deleteChunks(key, *manifest)
+		}
+	}
+}
+
+// Runs fn for each key in its own goroutine, with at most fileMultiConcurrency of them at a time, and waits for all
+// of them
+func forEachFile(keys []string, fn func(key string)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, fileMultiConcurrency)
+
+	for _, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fn(key)
+		}(key)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// Durability selects how the file cache makes writes durable before they are visible
+type Durability int
+
+const (
+	// DurabilityNone writes values atomically but leaves flushing to the operating system
+	DurabilityNone Durability = iota
+	// DurabilityFile fsyncs every cache file before it replaces the previous one
+	DurabilityFile
+	// DurabilityDirectory also fsyncs the directory, so that the rename itself survives a crash
+	DurabilityDirectory
+)
+
+// EvictionPolicy selects which files the file cache removes first when it is over its quota
+type EvictionPolicy int
+
+const (
+	// EvictLRU removes the files that were read or written least recently
+	EvictLRU EvictionPolicy = iota
+	// EvictOldest removes the files that were written first
+	EvictOldest
+)
+
+// Option configures optional behaviour of a cache. Options that do not apply to the selected cache type are ignored
+type Option func(*options)
+
+type options struct {
+	maxEntries    int
+	prefix        string
+	database      int
+	sliding       bool
+	durability    Durability
+	maxBytes      int64
+	maxFiles      int
+	eviction      EvictionPolicy
+	janitor       time.Duration
+	quarantine    bool
+	segmentSize   int64
+	namespace     string
+	fileMode      os.FileMode
+	dirMode       os.FileMode
+	uid           int
+	gid           int
+	createDir     bool
+	mmapThreshold int64
+	ketama        bool
+	weights       map[string]int
+	healthCheck   time.Duration
+	ejectAfter    int
+	batchItems    int
+	batchDelay    time.Duration
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		durability:    DurabilityFile,
+		eviction:      EvictLRU,
+		janitor:       time.Minute,
+		segmentSize:   defaultLogSegmentSize,
+		fileMode:      defaultFileMode,
+		dirMode:       defaultDirMode,
+		uid:           -1,
+		gid:           -1,
+		createDir:     true,
+		mmapThreshold: defaultMmapThreshold,
+		healthCheck:   defaultHealthCheckInterval,
+		ejectAfter:    defaultEjectAfter,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithMaxEntries limits the number of items held by the memory cache. The oldest item is evicted once the limit is reached.
+// 0 means no limit
+func WithMaxEntries(maxEntries int) Option {
+	return func(o *options) {
+		if maxEntries > 0 {
+			o.maxEntries = maxEntries
+		}
+	}
+}
+
+// WithPrefix prepends prefix to every key stored in redis or memcache so that several applications can share a server
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithDatabase selects the redis logical database
+func WithDatabase(database int) Option {
+	return func(o *options) {
+		o.database = database
+	}
+}
+
+// WithSlidingExpiration resets the expiration of an item to now + expiration each time it is read successfully,
+// so that only items that are not used expire
+func WithSlidingExpiration() Option {
+	return func(o *options) {
+		o.sliding = true
+	}
+}
+
+// WithDurability sets the durability of the writes of the file cache. Defaults to DurabilityFile
+func WithDurability(durability Durability) Option {
+	return func(o *options) {
+		o.durability = durability
+	}
+}
+
+// WithMaxBytes limits the total size of the files of the file cache. 0 means no limit
+func WithMaxBytes(maxBytes int64) Option {
+	return func(o *options) {
+		if maxBytes > 0 {
+			o.maxBytes = maxBytes
+		}
+	}
+}
+
+// WithMaxFiles limits the number of files of the file cache. 0 means no limit
+func WithMaxFiles(maxFiles int) Option {
+	return func(o *options) {
+		if maxFiles > 0 {
+			o.maxFiles = maxFiles
+		}
+	}
+}
+
+// WithEvictionPolicy sets which files are removed first when the file cache is over its quota. Defaults to EvictLRU
+func WithEvictionPolicy(eviction EvictionPolicy) Option {
+	return func(o *options) {
+		o.eviction = eviction
+	}
+}
+
+// WithJanitorInterval sets how often the file cache enforces its quota, the log cache compacts its segments and the
+// bolt cache removes expired keys.
+// Defaults to 1 minute
+func WithJanitorInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.janitor = interval
+		}
+	}
+}
+
+// WithQuarantine moves corrupted files of the file cache to the quarantine subdirectory for inspection instead of
+// removing them
+func WithQuarantine() Option {
+	return func(o *options) {
+		o.quarantine = true
+	}
+}
+
+// WithSegmentSize sets the size at which the log cache starts a new segment file. Defaults to 64 MB
+func WithSegmentSize(segmentSize int64) Option {
+	return func(o *options) {
+		if segmentSize > 0 {
+			o.segmentSize = segmentSize
+		}
+	}
+}
+
+// WithNamespace stores the keys of the bolt cache in a bucket of that name, so that several caches can share a
+// database file. The caches of one process that open the same file share the database, which is closed by Close of
+// the last of them. Defaults to "cache"
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithFileMode sets the permissions of the files created by the file, log and bolt caches, e.g. 0600 for values that
+// must only be readable by the owner. Defaults to 0644
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode.Perm()
+	}
+}
+
+// WithDirMode sets the permissions of the directories created by the file, log and bolt caches. Defaults to 0755
+func WithDirMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.dirMode = mode.Perm()
+	}
+}
+
+// WithOwner changes the owner of the files and directories created by the file, log and bolt caches. -1 keeps the
+// current user or group. Changing the owner usually requires privileges and is not supported on windows
+func WithOwner(uid, gid int) Option {
+	return func(o *options) {
+		o.uid = uid
+		o.gid = gid
+	}
+}
+
+// WithCreateDir sets whether the file, log and bolt caches create their directory when it does not exist. When
+// disabled the constructor fails with ErrCreatingFile instead. Defaults to true
+func WithCreateDir(create bool) Option {
+	return func(o *options) {
+		o.createDir = create
+	}
+}
+
+// WithMmapThreshold sets the size from which GetMapped memory maps the files of the file cache instead of reading
+// them. 0 maps every file. Defaults to 1 MB
+func WithMmapThreshold(threshold int64) Option {
+	return func(o *options) {
+		if threshold >= 0 {
+			o.mmapThreshold = threshold
+		}
+	}
+}
+
+// WithConsistentHashing places the keys of the memcache caches on a ketama compatible hash ring instead of
+// distributing them by modulo, so that adding or removing a server only moves the keys of that server. weights maps
+// a server, as passed to the constructor, to its share of the keys. Servers without a positive weight have weight 1.
+// Servers that fail their health checks are ejected from the ring until they answer again, see WithHealthCheck
+func WithConsistentHashing(weights map[string]int) Option {
+	return func(o *options) {
+		o.ketama = true
+		o.weights = make(map[string]int, len(weights))
+		for server, weight := range weights {
+			if weight > 0 {
+				o.weights[server] = weight
+			}
+		}
+	}
+}
+
+// WithHealthCheck sets how often the servers of a consistent hash ring are checked and after how many consecutive
+// failed checks a server is ejected. Ejected servers are re-admitted after their first successful check.
+// Defaults to every 5 seconds and 2 failures
+func WithHealthCheck(interval time.Duration, ejectAfter int) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.healthCheck = interval
+		}
+
+		if ejectAfter > 0 {
+			o.ejectAfter = ejectAfter
+		}
+	}
+}
+
+// WithWriteBatching makes the redis cache collect Set and Delete calls and send them in one pipeline once maxItems
+// writes are pending or maxDelay passed since the first of them. Set and Delete wait for their batch, SetAsync and
+// DeleteAsync of BatchWriter return a future instead. The other operations send the pending writes first, so that
+// they see them.
+// A maxDelay of 0 sends batches after 1 millisecond
+func WithWriteBatching(maxItems int, maxDelay time.Duration) Option {
+	return func(o *options) {
+		if maxItems > 0 {
+			o.batchItems = maxItems
+			o.batchDelay = defaultBatchDelay
+		}
+
+		if maxDelay > 0 {
+			o.batchDelay = maxDelay
+		}
+	}
+}
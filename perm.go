@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Mode of the files that are created when no file mode is configured
+	defaultFileMode = os.FileMode(0644)
+	// Mode of the directories that are created when no directory mode is configured
+	defaultDirMode = os.FileMode(0755)
+)
+
+// permissions holds the mode and the owner that the file based caches give to the files and directories they create
+type permissions struct {
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	uid      int
+	gid      int
+}
+
+func newPermissions(o options) permissions {
+	return permissions{
+		fileMode: o.fileMode,
+		dirMode:  o.dirMode,
+		uid:      o.uid,
+		gid:      o.gid,
+	}
+}
+
+// Creates the directory and any missing parents. The mode is set explicitly, so that it does not depend on the umask
+func (p permissions) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+
+		return nil
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := p.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, p.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := os.Chmod(dir, p.dirMode); err != nil {
+		return err
+	}
+
+	return p.chown(dir)
+}
+
+// Sets the mode and the owner of a file that was just created
+func (p permissions) apply(file *os.File) error {
+	if err := file.Chmod(p.fileMode); err != nil {
+		return err
+	}
+
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+
+	return file.Chown(p.uid, p.gid)
+}
+
+// Changes the owner of the path if an owner is configured
+func (p permissions) chown(path string) error {
+	if p.uid < 0 && p.gid < 0 {
+		return nil
+	}
+
+	return os.Chown(path, p.uid, p.gid)
+}
+
+// Makes sure that dir is a writable directory, creating it first if create is set. Returns ErrCreatingFile describing
+// the problem otherwise, so that an unusable path is reported by the constructor instead of by the first write
+func (p permissions) prepareDir(dir string, create bool) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) && create {
+		if err := p.mkdirAll(dir); err != nil {
+			return fmt.Errorf("%v: %w", ErrCreatingFile, err)
+		}
+
+		info, err = os.Stat(dir)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%v: %s is not a directory", ErrCreatingFile, dir)
+	}
+
+	probe, err := ioutil.TempFile(dir, tempFilePrefix)
+	if err != nil {
+		return fmt.Errorf("%v: %w", ErrCreatingFile, err)
+	}
+
+	_ = probe.Close()
+
+	return os.Remove(probe.Name())
+}
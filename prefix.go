@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"strings"
+)
+
+// This deletes the cache for all the keys that start with prefix. Returns ErrNotSupported for memcache, which cannot
+// list its keys
+func (c *cache) DeletePrefix(prefix string) error {
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		for key := range c.items {
+			if strings.HasPrefix(key, prefix) {
+				delete(c.items, key)
+			}
+		}
+	case cacheTypeFile:
+		for key := range c.cacheFiles {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			if err := c.removeLockedCacheFile(key); err != nil {
+				return err
+			}
+
+			delete(c.cacheFiles, key)
+		}
+	case cacheTypeRedis:
+		iter := c.redisClient.Scan(0, escapeRedisPattern(c.key(prefix))+"*", 100).Iterator()
+		for iter.Next() {
+			if err := c.redisClient.Del(iter.Val()).Err(); err != nil {
+				return err
+			}
+		}
+
+		return iter.Err()
+	case cacheTypeMemcache, cacheTypeMetaMemcache:
+		return ErrNotSupported
+	case cacheTypeLog:
+		return c.logStore.removePrefix(prefix)
+	case cacheTypeBolt:
+		return c.deleteBoltPrefix(prefix)
+	}
+
+	return nil
+}
+
+// Escapes the characters that have a special meaning in redis glob-style patterns
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
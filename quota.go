@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Remembers when the cache file of the key was last read or written, which the LRU eviction is based on.
+// Only tracked if the file cache has a quota
+func (c *cache) recordAccess(key string) {
+	if c.maxBytes <= 0 && c.maxFiles <= 0 {
+		return
+	}
+
+	c.accessMu.Lock()
+	defer c.accessMu.Unlock()
+
+	c.fileAccess[key] = time.Now().UnixNano()
+}
+
+// Removes cache files until the file cache is within its quota. Sizes are taken from the disk, so the accounting is
+// correct after a restart. Files that were not accessed since the start are ranked by their modification time
+func (c *cache) enforceFileQuota() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type candidate struct {
+		key  string
+		size int64
+		rank int64
+	}
+
+	c.accessMu.Lock()
+	candidates := make([]candidate, 0, len(c.cacheFiles))
+	var total int64
+
+	for key := range c.cacheFiles {
+		info, err := os.Stat(c.cacheFilePath(key))
+		if err != nil {
+			if os.IsNotExist(err) {
+				delete(c.cacheFiles, key)
+				delete(c.fileAccess, key)
+			}
+			continue
+		}
+
+		rank := info.ModTime().UnixNano()
+		if accessed, found := c.fileAccess[key]; found && c.eviction == EvictLRU && accessed > rank {
+			rank = accessed
+		}
+
+		candidates = append(candidates, candidate{key: key, size: info.Size(), rank: rank})
+		total += info.Size()
+	}
+	c.accessMu.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].rank < candidates[j].rank
+	})
+
+	count := len(candidates)
+	for _, victim := range candidates {
+		if (c.maxFiles <= 0 || count <= c.maxFiles) && (c.maxBytes <= 0 || total <= c.maxBytes) {
+			break
+		}
+
+		if err := c.removeLockedCacheFile(victim.key); err != nil {
+			continue
+		}
+
+		delete(c.cacheFiles, victim.key)
+		c.accessMu.Lock()
+		delete(c.fileAccess, victim.key)
+		c.accessMu.Unlock()
+
+		count--
+		total -= victim.size
+	}
+}
@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/md5"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Number of md5 digests per server of average weight on the ketama ring. Each digest yields four points
+const ketamaDigestsPerServer = 40
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultEjectAfter          = 2
+)
+
+// ketamaRing is a memcache.ServerSelector that places keys on a consistent hash ring compatible with libketama, so
+// that adding or removing a server only moves the keys of that server. Servers that fail their health checks are
+// ejected from the ring and re-admitted once they answer again
+type ketamaRing struct {
+	mu         sync.RWMutex
+	nodes      []*ringNode
+	points     []ringPoint
+	ejectAfter int
+	timeout    time.Duration
+}
+
+type ringNode struct {
+	name     string
+	addr     net.Addr
+	weight   int
+	failures int
+	ejected  bool
+}
+
+type ringPoint struct {
+	hash uint32
+	node *ringNode
+}
+
+// Returns a ring of the servers. weights maps a server to its weight, servers without a weight have weight 1.
+// A server that is listed several times gets the sum of its weights like in memcache.ServerList
+func newKetamaRing(servers []string, weights map[string]int, ejectAfter int) (*ketamaRing, error) {
+	r := &ketamaRing{ejectAfter: ejectAfter, timeout: memcache.DefaultTimeout}
+	nodes := make(map[string]*ringNode)
+
+	for _, server := range servers {
+		weight := 1
+		if w, found := weights[server]; found {
+			weight = w
+		}
+
+		if node, found := nodes[server]; found {
+			node.weight += weight
+			continue
+		}
+
+		addr, err := resolveServer(server)
+		if err != nil {
+			return nil, err
+		}
+
+		node := &ringNode{name: server, addr: addr, weight: weight}
+		nodes[server] = node
+		r.nodes = append(r.nodes, node)
+	}
+
+	r.build()
+
+	return r, nil
+}
+
+// Returns the server selector of the memcache caches, which is a ketama ring if consistent hashing is enabled
+func newServerSelector(servers []string, o options) (memcache.ServerSelector, *ketamaRing, error) {
+	if !o.ketama {
+		selector := new(memcache.ServerList)
+		return selector, nil, selector.SetServers(servers...)
+	}
+
+	ring, err := newKetamaRing(servers, o.weights, o.ejectAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ring, ring, nil
+}
+
+// Checks the health of the servers of the ring each interval
+func (c *cache) runHealthChecks(ring *ketamaRing, interval time.Duration) {
+	c.janitor = &cacheCleaner{
+		interval: time.NewTimer(interval),
+		stop:     make(chan bool),
+	}
+
+	c.runJanitor(interval, ring.checkHealth)
+}
+
+// PickServer returns the first server on the ring at or after the hash of the key
+func (r *ketamaRing) PickServer(key string) (net.Addr, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+
+	hash := ketamaHash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].node.addr, nil
+}
+
+// Each calls f for every server that is not ejected
+func (r *ketamaRing) Each(f func(net.Addr) error) error {
+	r.mu.RLock()
+	addrs := make([]net.Addr, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		if !node.ejected {
+			addrs = append(addrs, node.addr)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, addr := range addrs {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pings every server. A server is ejected after ejectAfter consecutive failures and re-admitted after its first
+// successful ping
+func (r *ketamaRing) checkHealth() {
+	r.mu.RLock()
+	nodes := append([]*ringNode{}, r.nodes...)
+	r.mu.RUnlock()
+
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, addr net.Addr) {
+			defer wg.Done()
+			errs[i] = pingServer(addr, r.timeout)
+		}(i, node.addr)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := false
+	for i, node := range nodes {
+		if errs[i] == nil {
+			node.failures = 0
+			if node.ejected {
+				node.ejected = false
+				changed = true
+			}
+
+			continue
+		}
+
+		node.failures++
+		if !node.ejected && node.failures >= r.ejectAfter {
+			node.ejected = true
+			changed = true
+		}
+	}
+
+	if changed {
+		r.build()
+	}
+}
+
+// Computes the points of the servers that are not ejected the way libketama does: every server gets
+// floor(weight / total weight * 40 * servers) md5 digests of "<server>-<n>" and every digest yields four points.
+// Callers must hold the write lock or own the ring exclusively
+func (r *ketamaRing) build() {
+	var live []*ringNode
+	total := 0
+
+	for _, node := range r.nodes {
+		if !node.ejected {
+			live = append(live, node)
+			total += node.weight
+		}
+	}
+
+	var points []ringPoint
+	for _, node := range live {
+		pct := float32(node.weight) / float32(total)
+		digests := int(math.Floor(float64(pct) * ketamaDigestsPerServer * float64(float32(len(live)))))
+
+		for n := 0; n < digests; n++ {
+			digest := md5.Sum([]byte(node.name + "-" + strconv.Itoa(n)))
+			for h := 0; h < 4; h++ {
+				points = append(points, ringPoint{hash: ketamaPoint(digest, h), node: node})
+			}
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	r.points = points
+}
+
+// Returns the hash of the key on the ring, which is the first point of its md5 digest
+func ketamaHash(key string) uint32 {
+	return ketamaPoint(md5.Sum([]byte(key)), 0)
+}
+
+// Returns the h-th little endian uint32 of the digest
+func ketamaPoint(digest [md5.Size]byte, h int) uint32 {
+	return uint32(digest[3+h*4])<<24 | uint32(digest[2+h*4])<<16 | uint32(digest[1+h*4])<<8 | uint32(digest[h*4])
+}
+
+// Resolves the server like memcache.ServerList does. Servers containing a slash are unix sockets
+func resolveServer(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		return net.ResolveUnixAddr("unix", server)
+	}
+
+	return net.ResolveTCPAddr("tcp", server)
+}
+
+// Sends version to the server, which both the text and the meta protocol answer
+func pingServer(addr net.Addr, timeout time.Duration) error {
+	nc, err := net.DialTimeout(addr.Network(), addr.String(), timeout)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	if err := nc.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := nc.Write([]byte("version\r\n")); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(nc).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(line, "VERSION") {
+		return fmt.Errorf("memcache: unexpected response %q", strings.TrimSpace(line))
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKetamaRingHash(t *testing.T) {
+	assert.Equal(t, uint32(0x2a40415d), ketamaHash("hello"))
+}
+
+func TestKetamaRingDistribution(t *testing.T) {
+	servers := []string{"127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213"}
+	ring, err := newKetamaRing(servers, nil, defaultEjectAfter)
+	assert.NoError(t, err)
+	assert.Len(t, ring.points, 3*160)
+
+	counts := make(map[string]int)
+	for i := 0; i < 30000; i++ {
+		addr, err := ring.PickServer("key_" + strconv.Itoa(i))
+		assert.NoError(t, err)
+		counts[addr.String()]++
+	}
+
+	for _, server := range servers {
+		assert.InDelta(t, 10000, counts[server], 3000)
+	}
+}
+
+func TestKetamaRingWeights(t *testing.T) {
+	ring, err := newKetamaRing([]string{"127.0.0.1:11211", "127.0.0.1:11212"}, map[string]int{"127.0.0.1:11211": 3}, defaultEjectAfter)
+	assert.NoError(t, err)
+	assert.Len(t, ring.points, 320)
+
+	counts := make(map[string]int)
+	for i := 0; i < 20000; i++ {
+		addr, err := ring.PickServer("key_" + strconv.Itoa(i))
+		assert.NoError(t, err)
+		counts[addr.String()]++
+	}
+
+	assert.True(t, counts["127.0.0.1:11211"] > 2*counts["127.0.0.1:11212"])
+}
+
+func TestKetamaRingRemovingServerOnlyMovesItsKeys(t *testing.T) {
+	all, err := newKetamaRing([]string{"127.0.0.1:11211", "127.0.0.1:11212", "127.0.0.1:11213"}, nil, defaultEjectAfter)
+	assert.NoError(t, err)
+
+	remaining, err := newKetamaRing([]string{"127.0.0.1:11211", "127.0.0.1:11212"}, nil, defaultEjectAfter)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10000; i++ {
+		key := "key_" + strconv.Itoa(i)
+		before, _ := all.PickServer(key)
+		after, _ := remaining.PickServer(key)
+
+		if before.String() != "127.0.0.1:11213" {
+			assert.Equal(t, before.String(), after.String())
+		}
+	}
+}
+
+func TestKetamaRingEjectsAndReadmitsServer(t *testing.T) {
+	healthy := newFakeMetaServer(t)
+	defer healthy.close()
+	failing := newFakeMetaServer(t)
+	defer failing.close()
+
+	ring, err := newKetamaRing([]string{healthy.addr(), failing.addr()}, nil, 2)
+	assert.NoError(t, err)
+
+	ring.checkHealth()
+	assert.Len(t, ring.points, 320)
+
+	failing.setDown(true)
+	ring.checkHealth()
+	assert.Len(t, ring.points, 320)
+
+	ring.checkHealth()
+	assert.Len(t, ring.points, 160)
+
+	for i := 0; i < 100; i++ {
+		addr, err := ring.PickServer("key_" + strconv.Itoa(i))
+		assert.NoError(t, err)
+		assert.Equal(t, healthy.addr(), addr.String())
+	}
+
+	servers := 0
+	_ = ring.Each(func(addr net.Addr) error {
+		servers++
+		return nil
+	})
+	assert.Equal(t, 1, servers)
+
+	failing.setDown(false)
+	ring.checkHealth()
+	assert.Len(t, ring.points, 320)
+}
+
+func TestKetamaRingErrorNoServers(t *testing.T) {
+	failing := newFakeMetaServer(t)
+	defer failing.close()
+
+	ring, err := newKetamaRing([]string{failing.addr()}, nil, 1)
+	assert.NoError(t, err)
+
+	failing.setDown(true)
+	ring.checkHealth()
+
+	_, err = ring.PickServer("cache_key")
+	assert.Equal(t, memcache.ErrNoServers, err)
+}
+
+func TestMetaMemCacheWithConsistentHashing(t *testing.T) {
+	first := newFakeMetaServer(t)
+	defer first.close()
+	second := newFakeMetaServer(t)
+	defer second.close()
+
+	cache, err := NewMetaMemCache(5 * time.Second, []string{first.addr(), second.addr()},
+		WithConsistentHashing(nil), WithHealthCheck(10 * time.Millisecond, 1))
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, cache.Set("key_"+strconv.Itoa(i), i))
+	}
+
+	first.mu.Lock()
+	assert.NotEmpty(t, first.items)
+	first.mu.Unlock()
+
+	second.setDown(true)
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		key := "key_" + strconv.Itoa(i)
+		assert.NoError(t, cache.Set(key, i))
+
+		value, err := cache.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(strconv.Itoa(i)), value)
+	}
+
+	first.mu.Lock()
+	assert.Len(t, first.items, 20)
+	first.mu.Unlock()
+
+	second.setDown(false)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, cache.Set("key_0", "value"))
+	value, err := cache.Get("key_0")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"value"`), value)
+}
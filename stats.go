@@ -0,0 +1,40 @@
+package cache
+
+import "sync/atomic"
+
+// Stats holds the counters of a cache since it was created
+type Stats struct {
+	// Hits is the number of Get and Pull calls that found a valid value
+	Hits uint64
+	// Misses is the number of Get and Pull calls that did not find a valid value
+	Misses uint64
+	// Corrupted is the number of corrupted file cache entries that were removed or quarantined
+	Corrupted uint64
+}
+
+// cacheStats is updated atomically. It is the first field of cache so that it is 64-bit aligned on 32-bit platforms
+type cacheStats struct {
+	hits      uint64
+	misses    uint64
+	corrupted uint64
+}
+
+// This returns the counters of the cache
+func (c *cache) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.stats.hits),
+		Misses:    atomic.LoadUint64(&c.stats.misses),
+		Corrupted: atomic.LoadUint64(&c.stats.corrupted),
+	}
+}
+
+// Counts the result of a lookup as hit or miss and returns it unchanged
+func (c *cache) countLookup(value []byte, err error) ([]byte, error) {
+	if err != nil {
+		atomic.AddUint64(&c.stats.misses, 1)
+	} else {
+		atomic.AddUint64(&c.stats.hits, 1)
+	}
+
+	return value, err
+}
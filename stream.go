@@ -0,0 +1,568 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Size of the chunks that streamed values are split into for redis and memcache. It is below the default item size
+// limit of memcache
+const streamChunkSize = 512 << 10
+
+// Values larger than this are stored in chunks by the memcache cache. It leaves room for the key and the item header
+// within the default item size limit of 1 MB
+const memcacheMaxValueSize = 1<<20 - 1024
+
+// Prefix of the manifest that is stored in place of a streamed value in redis and memcache. Values stored by Set are
+// JSON and never start with a zero byte
+var streamManifestMagic = []byte("\x00cache-stream:")
+
+// Sets the key in redis, or deletes it if ARGV[1] is 1, and returns the previous value if it was the manifest of a
+// streamed value, so that its chunks can be removed
+var redisReplace = redis.NewScript(`
+local previous = false
+if redis.call("TYPE", KEYS[1]).ok == "string" then
+	previous = redis.call("GET", KEYS[1])
+end
+if ARGV[1] == "1" then
+	redis.call("DEL", KEYS[1])
+elseif tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+if previous and string.sub(previous, 1, string.len(ARGV[4])) == ARGV[4] then
+	return previous
+end
+return false
+`)
+
+// streamManifest describes the chunks of a value that was stored with SetStream in redis or memcache
+type streamManifest struct {
+	ID       string `json:"id"`
+	Chunks   int    `json:"chunks"`
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// SetStream stores the content of the reader as is, without encoding it as JSON. The file cache writes it straight
+// to disk, redis and memcache store it in chunks of 512 KB with a manifest under the key, and the other cache types
+// read it into memory once.
+// The chunks of a streamed value are removed when the key is overwritten or deleted and take the ttl that Touch and
+// Persist set for the key
+func (c *cache) SetStream(key string, r io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	switch c.cacheType {
+	case cacheTypeFile:
+		if err := c.writeStreamFile(key, r); err != nil {
+			return err
+		}
+
+		c.cacheFiles[key] = struct{}{}
+
+		return nil
+	case cacheTypeRedis, cacheTypeMemcache, cacheTypeMetaMemcache:
+		return c.setChunkedStream(key, r)
+	}
+
+	val, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return c.setBytes(key, val)
+}
+
+// GetStream returns a reader of the value in the cache for the given key, which must be closed. The file cache reads
+// it straight from disk and verifies the checksum at the end, redis and memcache fetch one chunk at a time.
+// Reading from the file cache does not extend a sliding expiration, since that requires rewriting the whole file.
+// Returns error if cache doesn't exist or expired
+func (c *cache) GetStream(key string) (io.ReadCloser, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	var (
+		stream io.ReadCloser
+		err    error
+	)
+
+	switch c.cacheType {
+	case cacheTypeFile:
+		stream, err = c.openStreamFile(key)
+	case cacheTypeRedis, cacheTypeMemcache, cacheTypeMetaMemcache:
+		stream, err = c.openChunkedStream(key)
+	default:
+		var value []byte
+		if value, err = c.get(key, false); err == nil {
+			stream = ioutil.NopCloser(bytes.NewReader(value))
+		}
+	}
+
+	if _, err := c.countLookup(nil, err); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// Streams the content of the reader to a temporary file that is renamed over the cache file. The header is written
+// first with the widest possible checksum and overwritten with the real one, padded with spaces, once the content
+// is complete
+func (c *cache) writeStreamFile(key string, r io.Reader) error {
+	header := newFileHeader(key, c.expiresAt())
+	header.Checksum = math.MaxUint32
+	header.Version = c.nextFileVersion(key)
+
+	placeholder, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	file, err := c.createTempFile(key)
+	if err != nil {
+		return err
+	}
+
+	checksum := crc32.New(crc32c)
+	if err := writeStreamContent(file, append(placeholder, '\n'), io.TeeReader(r, checksum)); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	header.Checksum = checksum.Sum32()
+
+	head, err := json.Marshal(header)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	if _, err := file.WriteAt(append(head, bytes.Repeat([]byte(" "), len(placeholder)-len(head))...), 0); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return c.commitTempFile(key, file)
+}
+
+func writeStreamContent(file *os.File, head []byte, r io.Reader) error {
+	if _, err := file.Write(head); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(file, r)
+
+	return err
+}
+
+// Opens the cache file of the given key and returns a reader of its value
+func (c *cache) openStreamFile(key string) (io.ReadCloser, error) {
+	file, err := os.Open(c.cacheFilePath(key))
+	if err != nil {
+		return nil, ErrCacheNotFound
+	}
+
+	reader := bufio.NewReader(file)
+
+	var header fileHeader
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		err = ErrCacheCorrupted
+	} else {
+		header, err = parseFileHeader(line, key)
+	}
+
+	if _, _, err := c.checkCacheFile(key, header, nil, err); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	c.recordAccess(key)
+
+	return &fileStream{
+		cache:    c,
+		file:     file,
+		reader:   reader,
+		hash:     crc32.New(crc32c),
+		checksum: header.Checksum,
+	}, nil
+}
+
+// fileStream reads the value of a cache file and returns ErrCacheCorrupted at the end if the checksum does not match
+type fileStream struct {
+	cache    *cache
+	file     *os.File
+	reader   *bufio.Reader
+	hash     hash.Hash32
+	checksum uint32
+}
+
+func (s *fileStream) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	_, _ = s.hash.Write(p[:n])
+
+	if err == io.EOF && s.hash.Sum32() != s.checksum {
+		atomic.AddUint64(&s.cache.stats.corrupted, 1)
+		return n, ErrCacheCorrupted
+	}
+
+	return n, err
+}
+
+func (s *fileStream) Close() error {
+	return s.file.Close()
+}
+
+// Stores the content of the reader in chunks and then the manifest under the key, so that readers never see a
+// partially written value. The chunks of the previous value are removed afterwards
+func (c *cache) setChunkedStream(key string, r io.Reader) error {
+	previous, _ := c.chunkedManifest(key)
+
+	manifest, err := c.writeChunks(key, r)
+	if err != nil {
+		return err
+	}
+
+	if err := c.setBytes(key, encodeStreamManifest(manifest)); err != nil {
+		c.deleteChunks(key, manifest)
+		return err
+	}
+
+	if previous != nil {
+		c.deleteChunks(key, *previous)
+	}
+
+	return nil
+}
+
+// Writes a value that is over the memcache item size limit in chunks and returns the manifest to store in its place,
+// together with the manifest so that the chunks can be removed if storing it fails. Smaller values are returned as
+// they are
+func (c *cache) chunkLargeValue(key string, val []byte) ([]byte, *streamManifest, error) {
+	if len(val) <= memcacheMaxValueSize {
+		return val, nil, nil
+	}
+
+	manifest, err := c.writeChunks(key, bytes.NewReader(val))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeStreamManifest(manifest), &manifest, nil
+}
+
+// Stores the content of the reader in chunks under a new id and returns their manifest. The chunks that were
+// written are removed again if it fails
+func (c *cache) writeChunks(key string, r io.Reader) (streamManifest, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return streamManifest{}, err
+	}
+
+	manifest := streamManifest{ID: hex.EncodeToString(id)}
+	checksum := crc32.New(crc32c)
+	chunk := make([]byte, streamChunkSize)
+
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if err := c.setBytes(chunkKey(key, manifest, manifest.Chunks), chunk[:n]); err != nil {
+				c.deleteChunks(key, manifest)
+				return streamManifest{}, err
+			}
+
+			_, _ = checksum.Write(chunk[:n])
+			manifest.Chunks++
+			manifest.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			c.deleteChunks(key, manifest)
+			return streamManifest{}, err
+		}
+	}
+
+	manifest.Checksum = checksum.Sum32()
+
+	return manifest, nil
+}
+
+// Returns a reader of the chunks of the value for the given key. A value that was stored by Set is returned as is
+func (c *cache) openChunkedStream(key string) (io.ReadCloser, error) {
+	value, err := c.getChunk(key)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok := parseStreamManifest(value)
+	if !ok {
+		return ioutil.NopCloser(bytes.NewReader(value)), nil
+	}
+
+	return &chunkedStream{cache: c, key: key, manifest: manifest}, nil
+}
+
+// Returns the complete value of a streamed value if value is a manifest, or value itself. Removes the chunks
+// depending on the last parameter
+func (c *cache) readChunked(key string, value []byte, err error, removeCurrent bool) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok := parseStreamManifest(value)
+	if !ok {
+		return value, nil
+	}
+
+	if removeCurrent {
+		defer c.deleteChunks(key, manifest)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, manifest.Size))
+	if _, err := io.Copy(buf, &chunkedStream{cache: c, key: key, manifest: manifest}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Returns the manifest stored under the key, or nil if the key holds no streamed value
+func (c *cache) chunkedManifest(key string) (*streamManifest, error) {
+	value, err := c.getChunk(key)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok := parseStreamManifest(value)
+	if !ok {
+		return nil, nil
+	}
+
+	return &manifest, nil
+}
+
+// Stores the value with setBytes and removes the chunks of the streamed value that it overwrote. Redis returns the
+// previous value from the script that stores the new one, memcache reads it first
+func (c *cache) replaceBytes(key string, val []byte) error {
+	switch c.cacheType {
+	case cacheTypeRedis:
+		manifest, err := replacedManifest(replaceRedis(c.redisClient, c.key(key), val, c.expiration))
+		if manifest != nil {
+			c.deleteChunks(key, *manifest)
+		}
+
+		return err
+	case cacheTypeMemcache, cacheTypeMetaMemcache:
+		if len(val) > memcacheMaxValueSize {
+			return c.setChunkedStream(key, bytes.NewReader(val))
+		}
+
+		previous, _ := c.chunkedManifest(key)
+		if err := c.setBytes(key, val); err != nil {
+			return err
+		}
+
+		if previous != nil {
+			c.deleteChunks(key, *previous)
+		}
+
+		return nil
+	}
+
+	return c.setBytes(key, val)
+}
+
+// Runs redisReplace, which sets the redis key to the value or deletes it if the value is nil
+func replaceRedis(client redis.Cmdable, storeKey string, val []byte, expiration time.Duration) *redis.Cmd {
+	remove := "0"
+	if val == nil {
+		remove = "1"
+	}
+
+	return redisReplace.Eval(client, []string{storeKey}, remove, val, expiration.Milliseconds(), streamManifestMagic)
+}
+
+// Returns the manifest of the streamed value that redisReplace overwrote or deleted, if any
+func replacedManifest(cmd *redis.Cmd) (*streamManifest, error) {
+	previous, err := cmd.String()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest, ok := parseStreamManifest([]byte(previous)); ok {
+		return &manifest, nil
+	}
+
+	return nil, nil
+}
+
+// Sets the ttl of the key and, if it holds a streamed value, of its chunks, so that they expire together
+func (c *cache) touchChunked(key string, ttl time.Duration) error {
+	if manifest, _ := c.chunkedManifest(key); manifest != nil {
+		for i := 0; i < manifest.Chunks; i++ {
+			if err := c.touchStored(chunkKey(key, *manifest, i), ttl); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.touchStored(key, ttl)
+}
+
+// Removes the chunks of the streamed value under the key, if it holds one
+func (c *cache) deleteChunkedValue(key string) {
+	if manifest, _ := c.chunkedManifest(key); manifest != nil {
+		c.deleteChunks(key, *manifest)
+	}
+}
+
+// Returns the raw value of a chunk or manifest, without treating it as a manifest
+func (c *cache) getChunk(key string) ([]byte, error) {
+	switch c.cacheType {
+	case cacheTypeRedis:
+		return c.getRedisCache(key, false)
+	case cacheTypeMetaMemcache:
+		return c.getMetaCache(key, false)
+	}
+
+	return c.getMemCache(key, false)
+}
+
+func (c *cache) deleteChunks(key string, manifest streamManifest) {
+	keys := make([]string, manifest.Chunks)
+	for i := range keys {
+		keys[i] = c.key(chunkKey(key, manifest, i))
+	}
+
+	if c.cacheType == cacheTypeRedis {
+		if len(keys) > 0 {
+			c.redisClient.Del(keys...)
+		}
+
+		return
+	}
+
+	for _, k := range keys {
+		if c.cacheType == cacheTypeMetaMemcache {
+			_, _ = c.metaClient.delete(k)
+		} else {
+			_ = c.memCacheClient.Delete(k)
+		}
+	}
+}
+
+// Returns the key of the nth chunk of a streamed value. The id keeps the chunks of concurrent writers apart
+func chunkKey(key string, manifest streamManifest, n int) string {
+	return fmt.Sprintf("%s#%s-%d", key, manifest.ID, n)
+}
+
+func encodeStreamManifest(manifest streamManifest) []byte {
+	head, _ := json.Marshal(manifest)
+
+	return append(append([]byte{}, streamManifestMagic...), head...)
+}
+
+func parseStreamManifest(value []byte) (streamManifest, bool) {
+	var manifest streamManifest
+
+	if !bytes.HasPrefix(value, streamManifestMagic) {
+		return manifest, false
+	}
+
+	if err := json.Unmarshal(value[len(streamManifestMagic):], &manifest); err != nil {
+		return manifest, false
+	}
+
+	return manifest, true
+}
+
+// chunkedStream reads the chunks of a streamed value one at a time. A missing chunk, or chunks that do not add up to
+// the size and checksum of the manifest, are reported as ErrCacheNotFound
+type chunkedStream struct {
+	cache    *cache
+	key      string
+	manifest streamManifest
+	next     int
+	chunk    []byte
+	read     int64
+	hash     hash.Hash32
+}
+
+func (s *chunkedStream) Read(p []byte) (int, error) {
+	if s.hash == nil {
+		s.hash = crc32.New(crc32c)
+	}
+
+	for len(s.chunk) == 0 {
+		if s.next >= s.manifest.Chunks {
+			if s.read != s.manifest.Size || s.hash.Sum32() != s.manifest.Checksum {
+				return 0, ErrCacheNotFound
+			}
+
+			return 0, io.EOF
+		}
+
+		chunk, err := s.cache.getChunk(chunkKey(s.key, s.manifest, s.next))
+		if err != nil {
+			return 0, ErrCacheNotFound
+		}
+
+		_, _ = s.hash.Write(chunk)
+		s.read += int64(len(chunk))
+		s.chunk = chunk
+		s.next++
+	}
+
+	n := copy(p, s.chunk)
+	s.chunk = s.chunk[n:]
+
+	return n, nil
+}
+
+func (s *chunkedStream) Close() error {
+	return nil
+}
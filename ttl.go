@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v7"
+	bolt "go.etcd.io/bbolt"
+)
+
+// NoExpiration is returned by TTL for a cache that never expires
+const NoExpiration time.Duration = -1
+
+// This returns the remaining time to live of the cache for the given key, or NoExpiration if it never expires.
+// Returns ErrNotSupported for memcache, which cannot report the expiration of an item
+func (c *cache) TTL(key string) (time.Duration, error) {
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	c.flushBatch()
+
+	defer c.readLock()()
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		item, found := c.items[key]
+		if !found {
+			return 0, ErrCacheNotFound
+		}
+
+		if item.expiration > 0 && time.Now().UnixNano() > item.expiration {
+			return 0, ErrCacheExpired
+		}
+
+		return remaining(item.expiration), nil
+	case cacheTypeFile:
+		header, err := c.readCacheFileHeader(key)
+		if err != nil {
+			return 0, ErrCacheNotFound
+		}
+
+		if header.expired() {
+			return 0, ErrCacheExpired
+		}
+
+		return remaining(header.Expires), nil
+	case cacheTypeRedis:
+		ttl, err := c.redisClient.PTTL(c.key(key)).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		switch ttl {
+		case -2:
+			return 0, ErrCacheNotFound
+		case -1:
+			return NoExpiration, nil
+		}
+
+		return ttl, nil
+	case cacheTypeMemcache:
+		return 0, ErrNotSupported
+	case cacheTypeMetaMemcache:
+		return c.ttlMetaCache(key)
+	case cacheTypeLog:
+		entry, found := c.logStore.lookup(key)
+		if !found {
+			return 0, ErrCacheNotFound
+		}
+
+		return remaining(entry.expires), nil
+	case cacheTypeBolt:
+		var expires int64
+
+		if err := c.boltDB.View(func(tx *bolt.Tx) error {
+			var err error
+			_, expires, err = c.boltGet(tx, key)
+			return err
+		}); err != nil {
+			return 0, err
+		}
+
+		return remaining(expires), nil
+	}
+
+	return 0, ErrCacheNotFound
+}
+
+// This sets the cache for the given key to expire after ttl from now without changing its value.
+// A ttl of 0*time.Second indicates the cache will never expire
+func (c *cache) Touch(key string, ttl time.Duration) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.touch(key, ttl)
+}
+
+// This removes the expiration of the cache for the given key, so that it never expires
+func (c *cache) Persist(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	c.flushBatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lockEntry(key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.touch(key, defaultExpiration)
+}
+
+func (c *cache) touch(key string, ttl time.Duration) error {
+	var expiration int64
+	if ttl > defaultExpiration {
+		expiration = time.Now().Add(ttl).UnixNano()
+	}
+
+	switch c.cacheType {
+	case cacheTypeDefault:
+		if _, err := c.getDefaultCache(key, false); err != nil {
+			return err
+		}
+
+		item := c.items[key]
+		item.expiration = expiration
+		c.items[key] = item
+	case cacheTypeFile:
+		header, value, err := c.readValidCacheFile(key)
+		if err != nil {
+			return err
+		}
+
+		header.Expires = expiration
+
+		return c.writeCacheFile(key, header, value)
+	case cacheTypeRedis, cacheTypeMemcache, cacheTypeMetaMemcache:
+		return c.touchChunked(key, ttl)
+	case cacheTypeLog:
+		return c.logStore.touch(key, expiration)
+	case cacheTypeBolt:
+		return c.boltDB.Update(func(tx *bolt.Tx) error {
+			if _, _, err := c.boltGet(tx, key); err != nil {
+				return err
+			}
+
+			return c.boltSetExpiry(tx, key, expiration)
+		})
+	}
+
+	return nil
+}
+
+// Sets the ttl of the key in redis or memcache
+func (c *cache) touchStored(key string, ttl time.Duration) error {
+	switch c.cacheType {
+	case cacheTypeRedis:
+		var exists *redis.IntCmd
+
+		if _, err := c.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+			exists = pipe.Exists(c.key(key))
+			if ttl > defaultExpiration {
+				pipe.PExpire(c.key(key), ttl)
+			} else {
+				pipe.Persist(c.key(key))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if exists.Val() == 0 {
+			return ErrCacheNotFound
+		}
+	case cacheTypeMemcache:
+		switch err := c.memCacheClient.Touch(c.key(key), c.memcacheExpiration(ttl)); err {
+		case nil:
+		case memcache.ErrCacheMiss:
+			return ErrCacheNotFound
+		default:
+			return err
+		}
+	case cacheTypeMetaMemcache:
+		return c.touchMetaCache(key, ttl)
+	}
+
+	return nil
+}
+
+// Returns the duration until the given unix time in nanoseconds, or NoExpiration if it is 0
+func remaining(expiration int64) time.Duration {
+	if expiration == 0 {
+		return NoExpiration
+	}
+
+	return time.Duration(expiration - time.Now().UnixNano())
+}
+
+// Memcache treats expirations longer than this as an absolute unix time
+const memcacheMaxRelativeExpiration = 30 * 24 * time.Hour
+
+// Converts a ttl to a memcache expiration. Partial seconds are rounded up, since 0 means never expire, and ttls over
+// 30 days are sent as the unix time at which they expire
+func (c *cache) memcacheExpiration(ttl time.Duration) int32 {
+	if ttl <= defaultExpiration {
+		return 0
+	}
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > memcacheMaxRelativeExpiration {
+		return int32(c.clock().Unix() + seconds)
+	}
+
+	return int32(seconds)
+}